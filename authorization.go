@@ -0,0 +1,221 @@
+package fiberoidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+type fiberOidcConfigLocalsKey struct{}
+type fiberOidcClaimsLocalsKey struct{}
+
+// Requirement is a check of the authenticated user, for use with Require.
+// Combine them with And, Or and Not
+type Requirement func(c *fiber.Ctx) bool
+
+// the config bound by ProtectedRoute, UnprotectedRoute or BearerRoute
+func boundConfig(c *fiber.Ctx) *Config {
+	if config, ok := c.Locals(fiberOidcConfigLocalsKey{}).(*Config); ok {
+		return config
+	}
+	return &configDefaults
+}
+
+// Require runs after ProtectedRoute, UnprotectedRoute or BearerRoute.
+// Without an auth it responds with the Unauthorized handler, and when the
+// requirement isn't met, with the Forbidden handler (a 403 by default)
+func Require(requirement Requirement) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		config := boundConfig(c)
+		if ProviderAuth(c) == nil {
+			return config.Unauthorized(c)
+		}
+		if !requirement(c) {
+			if config.Forbidden != nil {
+				return config.Forbidden(c)
+			}
+			return configDefaults.Forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+func RequireScopes(scopes ...string) fiber.Handler {
+	return Require(HasScopes(scopes...))
+}
+
+func RequireAnyRole(roles ...string) fiber.Handler {
+	return Require(HasAnyRole(roles...))
+}
+
+func RequireAllGroups(groups ...string) fiber.Handler {
+	return Require(HasAllGroups(groups...))
+}
+
+func RequireClaim(path string, values ...string) fiber.Handler {
+	return Require(HasClaim(path, values...))
+}
+
+func And(requirements ...Requirement) Requirement {
+	return func(c *fiber.Ctx) bool {
+		for _, requirement := range requirements {
+			if !requirement(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func Or(requirements ...Requirement) Requirement {
+	return func(c *fiber.Ctx) bool {
+		for _, requirement := range requirements {
+			if requirement(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(requirement Requirement) Requirement {
+	return func(c *fiber.Ctx) bool {
+		return !requirement(c)
+	}
+}
+
+// HasScopes requires all of the scopes, from the token response (or
+// introspection), otherwise from the 'scope' or 'scp' claim
+func HasScopes(scopes ...string) Requirement {
+	return func(c *fiber.Ctx) bool {
+		granted := grantedScopes(ProviderAuth(c), claims(c))
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// HasAnyRole requires at least one of the roles, in the RolesClaim
+func HasAnyRole(roles ...string) Requirement {
+	return func(c *fiber.Ctx) bool {
+		granted := claimValues(claims(c), boundConfig(c).RolesClaim)
+		return slices.ContainsFunc(roles, func(role string) bool {
+			return slices.Contains(granted, role)
+		})
+	}
+}
+
+// HasAllGroups requires all of the groups, in the GroupsClaim
+func HasAllGroups(groups ...string) Requirement {
+	return func(c *fiber.Ctx) bool {
+		granted := claimValues(claims(c), boundConfig(c).GroupsClaim)
+		for _, group := range groups {
+			if !slices.Contains(granted, group) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// HasClaim requires the claim at the (dotted) path to have one of the values,
+// or with no values, to be present and not false or blank
+// eg: HasClaim("email_verified"), HasClaim("realm_access.roles", "admin")
+func HasClaim(path string, values ...string) Requirement {
+	return func(c *fiber.Ctx) bool {
+		claimed := claimValues(claims(c), path)
+		if len(values) == 0 {
+			return len(claimed) != 0 && !(len(claimed) == 1 && claimed[0] == "false")
+		}
+		return slices.ContainsFunc(values, func(value string) bool {
+			return slices.Contains(claimed, value)
+		})
+	}
+}
+
+// the scopes from the token response (or introspection), otherwise from
+// the 'scope' or 'scp' claim (eg: of an id token used as a bearer token)
+func grantedScopes(userAuth *provider.ProviderAuth, claims map[string]any) []string {
+	if len(userAuth.Scopes) != 0 {
+		return userAuth.Scopes
+	}
+	for _, claim := range []string{"scope", "scp"} {
+		// the only claims that are space separated
+		if scope, ok := claims[claim].(string); ok {
+			return strings.Fields(scope)
+		}
+		if scopes := claimValues(claims, claim); len(scopes) != 0 {
+			return scopes
+		}
+	}
+	return nil
+}
+
+// the claims of the bound auth, decoded once per request
+func claims(c *fiber.Ctx) map[string]any {
+	if cached, ok := c.Locals(fiberOidcClaimsLocalsKey{}).(map[string]any); ok {
+		return cached
+	}
+	decoded := decodeClaims(ProviderAuth(c))
+	c.Locals(fiberOidcClaimsLocalsKey{}, decoded)
+	return decoded
+}
+
+// empty if there are none. Numbers are kept as json.Number, so that
+// they can be compared exactly
+func decodeClaims(userAuth *provider.ProviderAuth) map[string]any {
+	raw := json.RawMessage{}
+	if userAuth == nil || userAuth.Claims(&raw) != nil {
+		return map[string]any{}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	decoded := map[string]any{}
+	if decoder.Decode(&decoded) != nil {
+		return map[string]any{}
+	}
+	return decoded
+}
+
+// claimValues flattens the claim at a dotted path into strings.
+// A string claim is a single (whole) value, and a blank one is absent
+func claimValues(claims map[string]any, path string) []string {
+	var value any = claims
+	for _, segment := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[segment]
+	}
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if typed == "" {
+			return nil
+		}
+		return []string{typed}
+	case []any:
+		values := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if item != nil {
+				values = append(values, fmt.Sprint(item))
+			}
+		}
+		return values
+	case map[string]any:
+		// present, but not comparable
+		return []string{""}
+	default:
+		return []string{fmt.Sprint(typed)}
+	}
+}
@@ -0,0 +1,217 @@
+package fiberoidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequire(t *testing.T) {
+	idp := newTestIdP(t, "corporate")
+	idp.config.AccessTokenAudiences = []string{"https://api.example.com"}
+	config := &Config{OidcProviderConfig: idp.config}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.BearerValidation = BearerValidationAccessToken
+	config.RolesClaim = "realm_access.roles"
+	config.Forbidden = func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusForbidden).SendString("denied")
+	}
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	}
+	app := fiber.New()
+	app.Get("/scopes", fiberOidc.BearerRoute(), RequireScopes("read", "write"), ok)
+	app.Get("/role", fiberOidc.BearerRoute(), RequireAnyRole("admin", "auditor"), ok)
+	app.Get("/groups", fiberOidc.BearerRoute(), RequireAllGroups("staff", "engineering"), ok)
+	app.Get("/verified", fiberOidc.BearerRoute(), RequireClaim("email_verified"), ok)
+	app.Get("/combined", fiberOidc.BearerRoute(),
+		Require(Or(HasAnyRole("admin"), And(HasClaim("department", "finance"), Not(HasAllGroups("contractors"))))), ok)
+	app.Get("/unprotected", fiberOidc.UnprotectedRoute(), RequireScopes(), ok)
+	app.Get("/department", fiberOidc.BearerRoute(), RequireClaim("department", "sales"), ok)
+	app.Get("/tenant", fiberOidc.BearerRoute(), RequireClaim("tenant_id", "12345678"), ok)
+	app.Get("/admins", fiberOidc.BearerRoute(), RequireAnyRole("Admins"), ok)
+
+	accessToken := func(modify func(claims map[string]any)) string {
+		claims := idp.claims("alice")
+		claims["aud"] = "https://api.example.com"
+		claims["scope"] = "read write"
+		claims["realm_access"] = map[string]any{"roles": []string{"auditor"}}
+		claims["groups"] = []string{"staff", "engineering"}
+		claims["email_verified"] = true
+		claims["department"] = "finance"
+		modify(claims)
+		return idp.sign(t, claims)
+	}
+	valid := accessToken(func(claims map[string]any) {})
+
+	for name, test := range map[string]struct {
+		path   string
+		token  string
+		status int
+	}{
+		"scopes":              {"/scopes", valid, fiber.StatusOK},
+		"missing scope":       {"/scopes", accessToken(func(claims map[string]any) { claims["scope"] = "read" }), fiber.StatusForbidden},
+		"role":                {"/role", valid, fiber.StatusOK},
+		"missing role":        {"/role", accessToken(func(claims map[string]any) { delete(claims, "realm_access") }), fiber.StatusForbidden},
+		"groups":              {"/groups", valid, fiber.StatusOK},
+		"missing group":       {"/groups", accessToken(func(claims map[string]any) { claims["groups"] = []string{"staff"} }), fiber.StatusForbidden},
+		"verified":            {"/verified", valid, fiber.StatusOK},
+		"unverified":          {"/verified", accessToken(func(claims map[string]any) { claims["email_verified"] = false }), fiber.StatusForbidden},
+		"combined":            {"/combined", valid, fiber.StatusOK},
+		"combined contractor": {"/combined", accessToken(func(claims map[string]any) { claims["groups"] = []string{"contractors"} }), fiber.StatusForbidden},
+		"combined admin": {"/combined", accessToken(func(claims map[string]any) {
+			claims["groups"] = []string{"contractors"}
+			claims["realm_access"] = map[string]any{"roles": []string{"admin"}}
+		}), fiber.StatusOK},
+		"unauthenticated": {"/unprotected", "", fiber.StatusUnauthorized},
+		// multi-word claims are whole values
+		"department":            {"/department", accessToken(func(claims map[string]any) { claims["department"] = "sales" }), fiber.StatusOK},
+		"multi-word department": {"/department", accessToken(func(claims map[string]any) { claims["department"] = "sales ops" }), fiber.StatusForbidden},
+		"multi-word role": {"/admins", accessToken(func(claims map[string]any) {
+			claims["realm_access"] = map[string]any{"roles": []string{"Domain Admins"}}
+		}), fiber.StatusForbidden},
+		"whole role": {"/admins", accessToken(func(claims map[string]any) {
+			claims["realm_access"] = map[string]any{"roles": "Admins"}
+		}), fiber.StatusOK},
+		// numbers are compared exactly
+		"tenant":       {"/tenant", accessToken(func(claims map[string]any) { claims["tenant_id"] = 12345678 }), fiber.StatusOK},
+		"other tenant": {"/tenant", accessToken(func(claims map[string]any) { claims["tenant_id"] = 12345679 }), fiber.StatusForbidden},
+	} {
+		req := httptest.NewRequest(fiber.MethodGet, test.path, nil)
+		if test.token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Fatalf("%v: Unexpected status: %v", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestClaimValues(t *testing.T) {
+	claims := map[string]any{
+		"department":   "sales ops",
+		"roles":        []any{"admin", json.Number("7")},
+		"realm_access": map[string]any{"roles": []any{"auditor"}},
+		"verified":     true,
+		"tenant_id":    json.Number("12345678"),
+		"blank":        "",
+	}
+	for path, expected := range map[string][]string{
+		"department":         {"sales ops"},
+		"roles":              {"admin", "7"},
+		"tenant_id":          {"12345678"},
+		"blank":              nil,
+		"realm_access.roles": {"auditor"},
+		"verified":           {"true"},
+		"missing":            nil,
+		"department.nested":  nil,
+	} {
+		if actual := claimValues(claims, path); !slices.Equal(actual, expected) {
+			t.Fatalf("%v: Unexpected values: %v", path, actual)
+		}
+	}
+}
+
+// scopes granted at login are kept with the token set, for the Require handlers
+func TestRequireScopesAfterLogin(t *testing.T) {
+	keyRing, err := NewKeyRing(CookieKey{Id: "k1", Secret: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, withStorage := range map[string]func(*Config){
+		"cookies": func(config *Config) {
+			config.AuthCookieName = "auth"
+			config.CookieCodec = keyRing
+		},
+		"session": func(config *Config) {
+			config.SessionStorage = NewMemoryStorage(time.Minute)
+		},
+	} {
+		idp := newTestIdP(t, "corporate")
+		nonce := atomic.Value{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := idp.claims("alice")
+			claims["nonce"] = nonce.Load()
+			w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "access-token",
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+				"scope":        "openid orders:read",
+				"id_token":     idp.sign(t, claims),
+			})
+		}))
+		idp.config.StaticMetadata.TokenEndpoint = server.URL
+		config := &Config{OidcProviderConfig: idp.config}
+		config.RedirectUri = "http://localhost:3000/oauth2/callback"
+		withStorage(config)
+		fiberOidc, err := New(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := fiberOidc.(*FiberOidcStruct)
+
+		ok := func(c *fiber.Ctx) error {
+			return c.SendString("ok")
+		}
+		app := fiber.New()
+		app.Get(fiberOidc.CallbackPath(), fiberOidc.CallbackHandler())
+		app.Get("/orders", fiberOidc.ProtectedRoute(), RequireScopes("orders:read"), ok)
+		app.Get("/admin", fiberOidc.ProtectedRoute(), RequireScopes("orders:write"), ok)
+
+		// log in
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/orders", nil))
+		if err != nil || resp.StatusCode != fiber.StatusFound {
+			t.Fatalf("%v: Unexpected response: %v %v", name, resp.StatusCode, err)
+		}
+		var loginStateCookie *http.Cookie
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == config.LoginStateCookieName {
+				loginStateCookie = cookie
+			}
+		}
+		state, err := obj.decodeLoginState(loginStateCookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce.Store(state.Nonce)
+		req := httptest.NewRequest(fiber.MethodGet, fiberOidc.CallbackPath()+"?code=code&state="+state.State, nil)
+		req.AddCookie(loginStateCookie)
+		resp, err = app.Test(req)
+		if err != nil || resp.StatusCode != fiber.StatusFound {
+			t.Fatalf("%v: Unexpected callback response: %v %v", name, resp.StatusCode, err)
+		}
+		cookies := resp.Cookies()
+
+		for target, expectedStatus := range map[string]int{"/orders": fiber.StatusOK, "/admin": fiber.StatusForbidden} {
+			req = httptest.NewRequest(fiber.MethodGet, target, nil)
+			for _, cookie := range cookies {
+				if cookie.Value != "" {
+					req.AddCookie(cookie)
+				}
+			}
+			resp, err = app.Test(req)
+			if err != nil || resp.StatusCode != expectedStatus {
+				t.Fatalf("%v: Unexpected status for %v: %v %v", name, target, resp.StatusCode, err)
+			}
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,59 @@
+package fiberoidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func (obj *FiberOidcStruct) BackChannelLogoutHandler() fiber.Handler {
+	return obj.handleBackChannelLogout
+}
+
+func logoutTokenReplayKey(logoutToken *provider.LogoutToken) string {
+	hash := sha256.Sum256([]byte(logoutToken.Issuer + "\x00" + logoutToken.Jti))
+	return "fiber-oidc-logout-jti:" + base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// receives a logout token (POSTed by the IdP), and revokes the sessions it identifies
+// see https://openid.net/specs/openid-connect-backchannel-1_0.html#BCRequest
+func (obj *FiberOidcStruct) handleBackChannelLogout(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	logoutToken, err := obj.Registry.VerifyLogoutToken(c.Context(), c.FormValue("logout_token"))
+	if err != nil {
+		return backChannelLogoutError(c, err.Error())
+	}
+
+	// each logout token is only accepted once. The storage has no atomic
+	// set-if-absent, so deliveries are serialized (within this instance)
+	obj.backChannelMutex.Lock()
+	defer obj.backChannelMutex.Unlock()
+	replayKey := logoutTokenReplayKey(logoutToken)
+	seen, err := obj.Config.LogoutStorage.Get(replayKey)
+	if err != nil {
+		return err
+	}
+	if seen != nil {
+		return backChannelLogoutError(c, "logout token has already been used")
+	}
+
+	err = obj.Config.RevocationList.Revoke(logoutToken)
+	if err != nil {
+		return err
+	}
+	// only once revoked, so that the IdP can retry a failure
+	err = obj.Config.LogoutStorage.Set(replayKey, []byte{1}, obj.Config.RevocationTTL)
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func backChannelLogoutError(c *fiber.Ctx, description string) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":             provider.CallbackErrorInvalidRequest,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,196 @@
+package fiberoidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+// signs tokens for a static provider
+type testIdP struct {
+	key    *rsa.PrivateKey
+	config provider.OidcProviderConfig
+}
+
+func newTestIdP(t *testing.T, name string) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: key.Public(), Use: "sig"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := staticProviderConfig(name)
+	config.StaticMetadata.Jwks = jwks
+	return &testIdP{key: key, config: config}
+}
+
+func (obj *testIdP) sign(t *testing.T, claims map[string]any) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: obj.key}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (obj *testIdP) claims(subject string) map[string]any {
+	return map[string]any{
+		"iss": obj.config.Issuer,
+		"aud": obj.config.ClientId,
+		"sub": subject,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	idp := newTestIdP(t, "corporate")
+	config := &Config{OidcProviderConfig: idp.config}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.AuthCookieName = "auth"
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/backchannel-logout", fiberOidc.BackChannelLogoutHandler())
+	app.Get("/protected", fiberOidc.ProtectedRoute(), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	exec := func(req *http.Request) int {
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	protected := func(idToken string) int {
+		req := httptest.NewRequest(fiber.MethodGet, "/protected", nil)
+		req.AddCookie(&http.Cookie{Name: "auth", Value: idToken})
+		return exec(req)
+	}
+	logout := func(logoutToken string) int {
+		form := url.Values{"logout_token": {logoutToken}}
+		req := httptest.NewRequest(fiber.MethodPost, "/backchannel-logout", strings.NewReader(form.Encode()))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		return exec(req)
+	}
+
+	claims := idp.claims("alice")
+	claims["sid"] = "alice-session"
+	idToken := idp.sign(t, claims)
+	otherIdToken := idp.sign(t, idp.claims("bob"))
+	if status := protected(idToken); status != fiber.StatusOK {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+
+	logoutClaims := idp.claims("alice")
+	delete(logoutClaims, "exp")
+	logoutClaims["sid"] = "alice-session"
+	logoutClaims["jti"] = "logout-1"
+	logoutClaims["events"] = map[string]any{provider.BackChannelLogoutEvent: map[string]any{}}
+	logoutToken := idp.sign(t, logoutClaims)
+	if status := logout(logoutToken); status != fiber.StatusOK {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+	if status := logout(logoutToken); status != fiber.StatusBadRequest {
+		t.Fatalf("Expected a replayed logout token to be rejected: %v", status)
+	}
+	if status := logout(idToken); status != fiber.StatusBadRequest {
+		t.Fatalf("Expected an id token to be rejected: %v", status)
+	}
+
+	if status := protected(idToken); status != fiber.StatusFound {
+		t.Fatalf("Expected the session to be revoked: %v", status)
+	}
+	if status := protected(otherIdToken); status != fiber.StatusOK {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+}
+
+// fails the first revocation, like a storage outage
+type failingRevocationList struct {
+	RevocationList
+	failures int
+}
+
+func (obj *failingRevocationList) Revoke(logoutToken *provider.LogoutToken) error {
+	if obj.failures > 0 {
+		obj.failures--
+		return errors.New("storage unavailable")
+	}
+	return obj.RevocationList.Revoke(logoutToken)
+}
+
+func TestBackChannelLogoutRetry(t *testing.T) {
+	idp := newTestIdP(t, "corporate")
+	storage := NewMemoryStorage(time.Minute)
+	defer storage.Close()
+	config := &Config{OidcProviderConfig: idp.config}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.RevocationList = &failingRevocationList{
+		RevocationList: &StorageRevocationList{Storage: storage, TTL: time.Hour},
+		failures:       1,
+	}
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/backchannel-logout", fiberOidc.BackChannelLogoutHandler())
+	logoutClaims := idp.claims("alice")
+	delete(logoutClaims, "exp")
+	logoutClaims["sid"] = "alice-session"
+	logoutClaims["jti"] = "logout-1"
+	logoutClaims["events"] = map[string]any{provider.BackChannelLogoutEvent: map[string]any{}}
+	form := url.Values{"logout_token": {idp.sign(t, logoutClaims)}}
+	logout := func() int {
+		req := httptest.NewRequest(fiber.MethodPost, "/backchannel-logout", strings.NewReader(form.Encode()))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := logout(); status != fiber.StatusInternalServerError {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+	// the IdP retries, and the token hasn't been marked as used
+	if status := logout(); status != fiber.StatusOK {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+	if status := logout(); status != fiber.StatusBadRequest {
+		t.Fatalf("Expected a replayed logout token to be rejected: %v", status)
+	}
+}
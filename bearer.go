@@ -0,0 +1,175 @@
+package fiberoidc
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+// RFC 6750 error codes
+// see https://www.rfc-editor.org/rfc/rfc6750#section-3.1
+const (
+	BearerErrorInvalidRequest    = "invalid_request"
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+)
+
+// BearerError is why a BearerRoute rejected a request
+type BearerError struct {
+	// 401, or 403 for insufficient scope
+	Status int
+
+	// blank when there was no token at all
+	Code        string
+	Description string
+
+	// the required scopes, for insufficient scope
+	Scopes []string
+
+	// the underlying error, if any
+	Err error
+}
+
+func (e *BearerError) Error() string {
+	if e.Code == "" {
+		return "no bearer token"
+	}
+	return fmt.Sprintf("%v: %v", e.Code, e.Description)
+}
+
+func (e *BearerError) Unwrap() error {
+	return e.Err
+}
+
+// the value for a WWW-Authenticate header
+func (e *BearerError) wwwAuthenticate(realm string) string {
+	params := make([]string, 0)
+	if realm != "" {
+		params = append(params, "realm="+quoteAuthParam(realm))
+	}
+	if e.Code != "" {
+		params = append(params, "error="+quoteAuthParam(e.Code))
+		if e.Description != "" {
+			params = append(params, "error_description="+quoteAuthParam(e.Description))
+		}
+	}
+	if len(e.Scopes) != 0 {
+		params = append(params, "scope="+quoteAuthParam(strings.Join(e.Scopes, " ")))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// RFC 6750 only allows printable ascii, without '"' or '\'
+func quoteAuthParam(value string) string {
+	quoted := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, value)
+	return `"` + quoted + `"`
+}
+
+// newBearerError classifies a validation error
+func newBearerError(err error) *BearerError {
+	bearerError := &BearerError{
+		Status: fiber.StatusUnauthorized,
+		Code:   BearerErrorInvalidToken,
+		Err:    err,
+	}
+	switch {
+	case errors.Is(err, provider.ErrTokenExpired):
+		bearerError.Description = "the token is expired"
+	case errors.Is(err, provider.ErrAudienceMismatch):
+		bearerError.Description = "the token is for a different audience"
+	case errors.Is(err, provider.ErrTokenInactive):
+		bearerError.Description = "the token is not active"
+	default:
+		bearerError.Description = "the token is invalid"
+	}
+	return bearerError
+}
+
+// default BearerErrorHandler
+func (cfg *WebAppConfig) sendBearerError(err *BearerError, c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, err.wwwAuthenticate(cfg.BearerRealm))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if !cfg.BearerProblemDetails {
+		return c.SendStatus(err.Status)
+	}
+	// see https://www.rfc-editor.org/rfc/rfc7807
+	problem := fiber.Map{
+		"title":  utils.StatusMessage(err.Status),
+		"status": err.Status,
+	}
+	if err.Code != "" {
+		problem["type"] = "https://www.rfc-editor.org/rfc/rfc6750#section-3.1"
+		problem["error"] = err.Code
+		problem["detail"] = err.Description
+	}
+	if len(err.Scopes) != 0 {
+		problem["scope"] = strings.Join(err.Scopes, " ")
+	}
+	return c.Status(err.Status).JSON(problem, "application/problem+json")
+}
+
+func (obj *FiberOidcStruct) BearerRoute(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// for the Require handlers
+		c.Locals(fiberOidcConfigLocalsKey{}, obj.Config)
+
+		token := bearerToken(c)
+		if token == "" {
+			return obj.Config.BearerErrorHandler(&BearerError{Status: fiber.StatusUnauthorized}, c)
+		}
+
+		userAuth, err := obj.validateTokens(c.Context(), &requestTokens{
+			idToken: token,
+			bearer:  true,
+		})
+		if err != nil {
+			if errors.Is(err, provider.ErrInitialization) {
+				return err
+			}
+			return obj.Config.BearerErrorHandler(newBearerError(err), c)
+		}
+		if userAuth.GetIdToken() != nil && obj.Config.RevocationList != nil {
+			revoked, err := obj.Config.RevocationList.IsRevoked(userAuth.GetIdToken())
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return obj.Config.BearerErrorHandler(&BearerError{
+					Status:      fiber.StatusUnauthorized,
+					Code:        BearerErrorInvalidToken,
+					Description: "the token has been revoked",
+				}, c)
+			}
+		}
+
+		if len(scopes) != 0 {
+			granted := grantedScopes(userAuth, decodeClaims(userAuth))
+			for _, scope := range scopes {
+				if !slices.Contains(granted, scope) {
+					return obj.Config.BearerErrorHandler(&BearerError{
+						Status:      fiber.StatusForbidden,
+						Code:        BearerErrorInsufficientScope,
+						Description: "the token does not have the required scope",
+						Scopes:      scopes,
+					}, c)
+				}
+			}
+		}
+
+		c.Locals(fiberOidcAuthLocalsKey{}, userAuth)
+		return c.Next()
+	}
+}
@@ -0,0 +1,131 @@
+package fiberoidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBearerRoute(t *testing.T) {
+	idp := newTestIdP(t, "corporate")
+	idp.config.AccessTokenAudiences = []string{"https://api.example.com"}
+	config := &Config{OidcProviderConfig: idp.config}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.BearerValidation = BearerValidationAccessToken
+	config.BearerRealm = "api"
+	config.BearerProblemDetails = true
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/api", fiberOidc.BearerRoute("write"), func(c *fiber.Ctx) error {
+		return c.SendString(ProviderAuth(c).Subject)
+	})
+	accessToken := func(modify func(claims map[string]any)) string {
+		claims := idp.claims("alice")
+		claims["aud"] = "https://api.example.com"
+		claims["scope"] = "read write"
+		modify(claims)
+		return idp.sign(t, claims)
+	}
+
+	for name, test := range map[string]struct {
+		token           string
+		status          int
+		wwwAuthenticate string
+	}{
+		"valid":   {accessToken(func(claims map[string]any) {}), fiber.StatusOK, ""},
+		"missing": {"", fiber.StatusUnauthorized, `Bearer realm="api"`},
+		"expired": {accessToken(func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }),
+			fiber.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="the token is expired"`},
+		"audience": {accessToken(func(claims map[string]any) { claims["aud"] = "corporate-client" }),
+			fiber.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="the token is for a different audience"`},
+		"scope": {accessToken(func(claims map[string]any) { claims["scope"] = "read" }),
+			fiber.StatusForbidden, `Bearer realm="api", error="insufficient_scope", error_description="the token does not have the required scope", scope="write"`},
+	} {
+		req := httptest.NewRequest(fiber.MethodGet, "/api", nil)
+		if test.token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || resp.Header.Get(fiber.HeaderWWWAuthenticate) != test.wwwAuthenticate {
+			t.Fatalf("%v: Unexpected response: %v %v", name, resp.StatusCode, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+		}
+		if test.status == fiber.StatusOK {
+			continue
+		}
+		if !strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), "application/problem+json") {
+			t.Fatalf("%v: Unexpected content type: %v", name, resp.Header.Get(fiber.HeaderContentType))
+		}
+		problem := map[string]any{}
+		if err = json.NewDecoder(resp.Body).Decode(&problem); err != nil || problem["status"] != float64(test.status) {
+			t.Fatalf("%v: Unexpected problem: %v %v", name, problem, err)
+		}
+	}
+
+	// never redirects, even for a browser
+	req := httptest.NewRequest(fiber.MethodGet, "/api", nil)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMETextHTML)
+	resp, err := app.Test(req)
+	if err != nil || resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("Unexpected response: %v %v", resp.StatusCode, err)
+	}
+}
+
+func TestBearerRouteIdToken(t *testing.T) {
+	idp := newTestIdP(t, "corporate")
+	config := &Config{OidcProviderConfig: idp.config}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/api", fiberOidc.BearerRoute("orders:read"), func(c *fiber.Ctx) error {
+		return c.SendString(ProviderAuth(c).Subject)
+	})
+	idToken := func(modify func(claims map[string]any)) string {
+		claims := idp.claims("alice")
+		claims["scope"] = "openid orders:read"
+		modify(claims)
+		return idp.sign(t, claims)
+	}
+
+	for name, test := range map[string]struct {
+		token           string
+		status          int
+		wwwAuthenticate string
+	}{
+		// the scopes come from the claims, as there is no token response
+		"scope claim": {idToken(func(claims map[string]any) {}), fiber.StatusOK, ""},
+		"scp claim": {idToken(func(claims map[string]any) {
+			delete(claims, "scope")
+			claims["scp"] = []string{"orders:read"}
+		}), fiber.StatusOK, ""},
+		"missing scope": {idToken(func(claims map[string]any) { claims["scope"] = "openid" }),
+			fiber.StatusForbidden, `Bearer error="insufficient_scope", error_description="the token does not have the required scope", scope="orders:read"`},
+		"audience": {idToken(func(claims map[string]any) { claims["aud"] = "https://other-api.example.com" }),
+			fiber.StatusUnauthorized, `Bearer error="invalid_token", error_description="the token is for a different audience"`},
+	} {
+		req := httptest.NewRequest(fiber.MethodGet, "/api", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status || resp.Header.Get(fiber.HeaderWWWAuthenticate) != test.wwwAuthenticate {
+			t.Fatalf("%v: Unexpected response: %v %v", name, resp.StatusCode, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+		}
+	}
+}
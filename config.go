@@ -1,16 +1,37 @@
 package fiberoidc
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"html/template"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	gooidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gofiber/fiber/v2"
 	"github.com/kncept/fiber-oidc/provider"
 )
 
+// How bearer tokens (the 'Authorization: Bearer' header) are validated
+type BearerValidation string
+
+const (
+	// as a jwt (the default)
+	BearerValidationJwt BearerValidation = "jwt"
+
+	// as a JWT access token for this API (see AccessTokenAudiences)
+	BearerValidationAccessToken BearerValidation = "access_token"
+
+	// at the introspection endpoint, for opaque access tokens
+	BearerValidationIntrospection BearerValidation = "introspection"
+
+	// as a jwt, falling back to the introspection endpoint when that fails
+	BearerValidationJwtThenIntrospection BearerValidation = "jwt_then_introspection"
+)
+
 // Config defines the config for middleware.
 type WebAppConfig struct {
 	// OPTIONAL, defaults to true
@@ -23,18 +44,114 @@ type WebAppConfig struct {
 	CallbackPath string
 
 	// OPTIONAL
-	// if set, also use an auth cookie (allow identity token to be set directly)
+	// if set, also use an auth cookie, which carries the raw ID token.
+	// N.B. the 'Authorization: Bearer' header also carries an ID token, and
+	// takes precedence over this cookie
 	AuthCookieName string
 
+	// OPTIONAL, defaults to BearerValidationJwt (an id token)
+	// How bearer tokens are validated. Tokens from cookies (or the session)
+	// are always validated as id tokens
+	BearerValidation BearerValidation
+
+	// OPTIONAL
+	// the realm in the WWW-Authenticate header of BearerRoute errors
+	BearerRealm string
+
 	// OPTIONAL
-	// if set, also use an auth cookie (allow identity token to be set directly)
+	// If true, BearerRoute errors have an RFC 7807 (application/problem+json) body
+	BearerProblemDetails bool
+
+	// OPTIONAL
+	// Called when a BearerRoute rejects a request.
+	// If unspecified, responds with the BearerError status, and an RFC 6750
+	// WWW-Authenticate header
+	BearerErrorHandler func(err *BearerError, c *fiber.Ctx) error
+
+	// OPTIONAL
+	// if set, also use a cookie that carries the access token.
+	// The access token is not validated, it is carried along with the ID token
+	// so that it can be used to call other APIs (see Oauth2TokenSource)
+	AccessTokenCookieName string
+
+	// OPTIONAL
+	// if set, also use a cookie that carries the refresh token
 	AuthRefreshCookieName string
 
+	// OPTIONAL, defaults to AuthRefreshCookieName + "-expiry" (if that, and an
+	// authenticating CookieCodec, eg: NewKeyRing, are set)
+	// Carries when a refresh without a new ID token expires, so that the IdP
+	// isn't asked to refresh on every request until then (see ProviderAuth.Expiry).
+	// It is bound to the ID token, and requires a CookieCodec so that it can't be forged
+	AuthExpiryCookieName string
+
+	// OPTIONAL, defaults to AuthCookieName + "-scope" (if that, and an
+	// authenticating CookieCodec are set)
+	// Carries the granted scopes, for RequireScopes/HasScopes. Like the expiry
+	// cookie, it is bound to the ID token and requires a CookieCodec.
+	// Without it, scopes come from the 'scope' or 'scp' claim of the ID token (if any)
+	AuthScopeCookieName string
+
+	// OPTIONAL
+	// If set, tokens are kept server side in this storage, and the browser only
+	// gets an opaque session id cookie. Token cookies can't be used with this.
+	// NewMemoryStorage is suitable for a single instance.
+	SessionStorage fiber.Storage
+
+	// OPTIONAL, defaults to "fiber-oidc-session"
+	SessionCookieName string
+
+	// OPTIONAL, defaults to 24 hours
+	// How long a session is kept for, extended every time its tokens are refreshed
+	SessionTTL time.Duration
+
+	// OPTIONAL
+	// Secure by default (HttpOnly, Secure, SameSite=Lax, Path=/)
+	CookiePolicy CookiePolicy
+
 	// OPTIONAL
-	// Unauthorized defines the response body for unauthorized responses.
+	// if set, encodes (and decodes) all cookie values.
+	// Use NewKeyRing to encrypt and authenticate cookie values, otherwise
+	// tokens are stored in plaintext cookies
+	CookieCodec CookieCodec
+
+	// OPTIONAL
+	// Unauthorized defines the response body for unauthorized responses
+	// (eg: from Require, when there is no auth).
 	// By default it will return with a 401 Unauthorized and the correct WWW-Auth header
 	Unauthorized fiber.Handler
 
+	// OPTIONAL
+	// Forbidden defines the response for an authenticated user that doesn't
+	// meet a requirement (see Require).
+	// By default it will return with a 403 Forbidden
+	Forbidden fiber.Handler
+
+	// OPTIONAL, defaults to "roles"
+	// The claim (or dotted path, eg: "realm_access.roles") with the users roles,
+	// for HasAnyRole
+	RolesClaim string
+
+	// OPTIONAL, defaults to "groups"
+	// The claim (or dotted path) with the users groups, for HasAllGroups
+	GroupsClaim string
+
+	// OPTIONAL, defaults to "fiber-oidc-login"
+	// Short lived cookie that binds the OIDC redirect to the browser, and
+	// carries the login state through to the callback
+	LoginStateCookieName string
+
+	// OPTIONAL, defaults to 10 minutes
+	// How long a user has to complete a login at the IdP
+	LoginStateTTL time.Duration
+
+	// OPTIONAL
+	// Secret used to encrypt and authenticate the login state cookie,
+	// when there is no CookieCodec.
+	// If unspecified, a random secret is generated on startup, which will
+	// NOT work if there are multiple instances behind a load balancer
+	LoginStateSecret []byte
+
 	// OPTIONAL
 	// Called to serialize state for the OIDC redirect
 	// If unspecified, will just the be the current path
@@ -47,14 +164,137 @@ type WebAppConfig struct {
 	// Called on login success to restore any application state there
 	// may have been.
 	// if unspecified, will assume that 'state' was the url path, and redirect there
+	// (only if it is a same-origin relative path, or is in the PostLoginRedirectAllowlist)
 	//
 	// Should be paired with a StateEncoder if provided
 	LoginSuccessHandler func(state string, c *fiber.Ctx) error
+
+	// OPTIONAL
+	//
+	// Called when the IdP returns an error on the callback (eg: the user
+	// cancelled consent), with the app state. Only called once the login state
+	// is verified, otherwise the callback fails with the login state error.
+	// Can show a friendly page, send the user home, or retry (see RedirectToLogin).
+	// If unspecified, responds with a 401 (400 for a misconfiguration, or 503
+	// if the IdP is unavailable)
+	LoginErrorHandler func(err *provider.CallbackError, state string, c *fiber.Ctx) error
+
+	// OPTIONAL
+	// Allows the default LoginSuccessHandler to redirect off-site.
+	// Entries may be a host ("app.example.com"), a wildcard host ("*.example.com"),
+	// or an absolute url prefix ("https://app.example.com/return/")
+	PostLoginRedirectAllowlist []string
+
+	// OPTIONAL, defaults to "/"
+	// Where the default LoginSuccessHandler redirects when the target is rejected
+	PostLoginFallbackPath string
+
+	// OPTIONAL
+	// FULLY QUALIFIED post logout callback, which must be registered with the IdP.
+	// If set, the IdP redirects back here after ending its session (see LogoutHandler)
+	PostLogoutRedirectUri string
+
+	// OPTIONAL
+	// trigger the post logout callback on this path.
+	// If blank, defaults to the entire path from the PostLogoutRedirectUri
+	PostLogoutCallbackPath string
+
+	// OPTIONAL, defaults to "/"
+	// Where the user ends up after logging out
+	PostLogoutLandingPath string
+
+	// OPTIONAL, defaults to "fiber-oidc-logout"
+	// Short lived cookie that binds the end session redirect to the browser
+	LogoutStateCookieName string
+
+	// OPTIONAL, defaults to true
+	// Revoke the refresh token (and access token, where supported) on logout,
+	// if the provider has a revocation_endpoint
+	RevokeTokensOnLogout *bool
+
+	// OPTIONAL
+	// Sessions that have been logged out at the IdP (see BackChannelLogoutHandler),
+	// which is checked on every request.
+	// If unspecified, a StorageRevocationList in the LogoutStorage
+	RevocationList RevocationList
+
+	// OPTIONAL
+	// Backs the default RevocationList, and the logout token replay cache.
+	// If unspecified, the SessionStorage is used, otherwise a MemoryStorage
+	// (which is only suitable for a single instance)
+	LogoutStorage fiber.Storage
+
+	// OPTIONAL, defaults to the SessionTTL
+	// How long revocations (and logout token ids) are remembered for.
+	// Should be at least as long as the longest session
+	RevocationTTL time.Duration
+
+	// OPTIONAL
+	// Register the FrontChannelLogoutHandler with the IdP with the same
+	// frontchannel_logout_session_required value.
+	// If true, front-channel logouts without an iss and sid are rejected.
+	// Either way, a logout with an iss and sid only clears a matching session
+	FrontChannelLogoutSessionRequired bool
+
+	// OPTIONAL, defaults to the origins of the (default and additional) provider issuers
+	// The frame-ancestors allowed to load the FrontChannelLogoutHandler, for an IdP
+	// that serves its logout page from a different origin than its issuer.
+	// Each is a CSP source expression, eg: "https://login.example.com" or "'self'"
+	FrontChannelLogoutFrameAncestors []string
+
+	// OPTIONAL
+	// Decides if a request that needs a login is a browser navigation, which
+	// is redirected to the IdP, or programmatic (XHR/fetch), which gets the
+	// LoginRequiredHandler instead.
+	// If unspecified, uses Sec-Fetch-Mode/Sec-Fetch-Dest, X-Requested-With, the
+	// method and the Accept header
+	IsNavigational func(c *fiber.Ctx) bool
+
+	// OPTIONAL
+	// Responds to a programmatic request that needs a login, with a url the
+	// app can send the user to. It carries the LoginStateEncoder output, and
+	// the app can add a return_to (eg: the current page of an SPA) to use instead.
+	// If unspecified, responds with a 401 and {"error":"login_required","login_url":"..."}
+	LoginRequiredHandler func(loginUrl string, c *fiber.Ctx) error
+
+	// OPTIONAL
+	// Where the LoginHandler is mounted. The login url for programmatic
+	// requests is this (with the app state), otherwise the CallbackPath, which
+	// also starts a login when it isn't a response from the IdP
+	LoginPath string
+
+	// OPTIONAL
+	// Shown by the LoginHandler when there is more than one provider, and
+	// none was requested.
+	// If unspecified, renders the ProviderChooserTemplate
+	ProviderChooser func(choices []ProviderChoice, c *fiber.Ctx) error
+
+	// OPTIONAL
+	// html/template for the default ProviderChooser, executed with the []ProviderChoice.
+	// If unspecified, a plain list of links
+	ProviderChooserTemplate *template.Template
 }
 
 type Config struct {
 	provider.OidcProviderConfig
 	WebAppConfig
+
+	// OPTIONAL, defaults to "default"
+	// The name of the embedded (default) provider
+	DefaultProviderName string
+
+	// OPTIONAL
+	// More providers, by name (see LoginOptions).
+	// Each is defaulted in the same way as the default provider, and the
+	// RedirectUri defaults to the default providers RedirectUri, as they
+	// all share the callback. Each must have a different Issuer.
+	AdditionalProviders map[string]provider.OidcProviderConfig
+
+	// OPTIONAL
+	// If set, New initializes the provider (ie: runs discovery) before it
+	// returns, and fails if that can't be done within this timeout.
+	// Otherwise initialization is lazy, on the first request that needs it
+	EagerInitTimeout time.Duration
 }
 
 // ConfigDefault is the default config
@@ -68,22 +308,42 @@ var configDefaults = Config{
 			"RS256",
 			"RS512",
 		},
+		UsePKCE: &boolTrue,
 	},
+	DefaultProviderName: "default",
 	WebAppConfig: WebAppConfig{
-		AutoRefreshOnExpiry: &boolTrue,
+		AutoRefreshOnExpiry:  &boolTrue,
+		BearerValidation:     BearerValidationJwt,
+		RevokeTokensOnLogout: &boolTrue,
+		LoginStateCookieName: "fiber-oidc-login",
+		LoginStateTTL:        10 * time.Minute,
+		SessionCookieName:    "fiber-oidc-session",
+		SessionTTL:           24 * time.Hour,
 		Unauthorized: func(c *fiber.Ctx) error {
 			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
 			return c.SendStatus(fiber.StatusUnauthorized)
 		},
+		Forbidden: func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusForbidden)
+		},
+		RolesClaim:  "roles",
+		GroupsClaim: "groups",
 		LoginStateEncoder: func(c *fiber.Ctx) (string, error) {
 			return c.Path(), nil
 		},
-		LoginSuccessHandler: func(state string, c *fiber.Ctx) error {
-			if state == "" {
-				state = "/"
+		LoginErrorHandler: func(err *provider.CallbackError, state string, c *fiber.Ctx) error {
+			switch err.Code {
+			case provider.CallbackErrorServerError, provider.CallbackErrorTemporarilyUnavailable:
+				return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+			case provider.CallbackErrorInvalidRequest, provider.CallbackErrorUnauthorizedClient,
+				provider.CallbackErrorUnsupportedResponseType, provider.CallbackErrorInvalidScope:
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
 			}
-			return c.Redirect(state, 302)
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
 		},
+		PostLoginFallbackPath: "/",
+		PostLogoutLandingPath: "/",
+		LogoutStateCookieName: "fiber-oidc-logout",
 	},
 }
 
@@ -93,9 +353,19 @@ func (cfg *Config) WithDefaults() *Config {
 		cfg = &Config{}
 	}
 
+	cfg.CookiePolicy.withDefaults()
 	if cfg.Unauthorized == nil {
 		cfg.Unauthorized = configDefaults.Unauthorized
 	}
+	if cfg.Forbidden == nil {
+		cfg.Forbidden = configDefaults.Forbidden
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = configDefaults.RolesClaim
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = configDefaults.GroupsClaim
+	}
 	if cfg.RedirectUri != "" && cfg.CallbackPath == "" {
 		// default to be the entire path in redirect url
 		u, err := url.Parse(cfg.RedirectUri)
@@ -103,25 +373,128 @@ func (cfg *Config) WithDefaults() *Config {
 			cfg.CallbackPath = u.Path
 		}
 	}
+	if cfg.PostLogoutRedirectUri != "" && cfg.PostLogoutCallbackPath == "" {
+		u, err := url.Parse(cfg.PostLogoutRedirectUri)
+		if err == nil {
+			cfg.PostLogoutCallbackPath = u.Path
+		}
+	}
+	if cfg.PostLogoutLandingPath == "" {
+		cfg.PostLogoutLandingPath = configDefaults.PostLogoutLandingPath
+	}
+	if cfg.LogoutStateCookieName == "" {
+		cfg.LogoutStateCookieName = configDefaults.LogoutStateCookieName
+	}
+	if cfg.LoginStateCookieName == "" {
+		cfg.LoginStateCookieName = configDefaults.LoginStateCookieName
+	}
+	if cfg.AuthRefreshCookieName != "" && cfg.AuthExpiryCookieName == "" && cfg.CookieCodec != nil {
+		cfg.AuthExpiryCookieName = cfg.AuthRefreshCookieName + "-expiry"
+	}
+	if cfg.AuthCookieName != "" && cfg.AuthScopeCookieName == "" && cfg.CookieCodec != nil {
+		cfg.AuthScopeCookieName = cfg.AuthCookieName + "-scope"
+	}
+	if cfg.SessionCookieName == "" {
+		cfg.SessionCookieName = configDefaults.SessionCookieName
+	}
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = configDefaults.SessionTTL
+	}
+	if cfg.RevocationTTL == 0 {
+		cfg.RevocationTTL = cfg.SessionTTL
+	}
+	if cfg.LoginStateTTL == 0 {
+		cfg.LoginStateTTL = configDefaults.LoginStateTTL
+	}
+	if len(cfg.LoginStateSecret) == 0 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err == nil {
+			cfg.LoginStateSecret = secret
+		}
+	}
 	if cfg.LoginStateEncoder == nil {
 		cfg.LoginStateEncoder = configDefaults.LoginStateEncoder
 	}
+	if cfg.LoginErrorHandler == nil {
+		cfg.LoginErrorHandler = configDefaults.LoginErrorHandler
+	}
+	if cfg.PostLoginFallbackPath == "" {
+		cfg.PostLoginFallbackPath = configDefaults.PostLoginFallbackPath
+	}
 	if cfg.LoginSuccessHandler == nil {
-		cfg.LoginSuccessHandler = configDefaults.LoginSuccessHandler
+		cfg.LoginSuccessHandler = cfg.redirectToState
 	}
-	if len(cfg.Scopes) == 0 {
-		cfg.Scopes = configDefaults.Scopes
+	if cfg.IsNavigational == nil {
+		cfg.IsNavigational = isNavigationalRequest
 	}
-	if len(cfg.SupportedSigningAlgs) == 0 {
-		cfg.SupportedSigningAlgs = configDefaults.SupportedSigningAlgs
+	if cfg.LoginRequiredHandler == nil {
+		cfg.LoginRequiredHandler = sendLoginRequired
+	}
+	if cfg.BearerErrorHandler == nil {
+		cfg.BearerErrorHandler = cfg.sendBearerError
+	}
+	if cfg.ProviderChooserTemplate == nil {
+		cfg.ProviderChooserTemplate = defaultProviderChooserTemplate
+	}
+	if cfg.ProviderChooser == nil {
+		cfg.ProviderChooser = cfg.renderProviderChooser
+	}
+	withProviderDefaults(&cfg.OidcProviderConfig)
+	if cfg.DefaultProviderName == "" {
+		cfg.DefaultProviderName = configDefaults.DefaultProviderName
+	}
+	for name, providerConfig := range cfg.AdditionalProviders {
+		if providerConfig.RedirectUri == "" {
+			providerConfig.RedirectUri = cfg.RedirectUri
+		}
+		withProviderDefaults(&providerConfig)
+		cfg.AdditionalProviders[name] = providerConfig
+	}
+	if len(cfg.FrontChannelLogoutFrameAncestors) == 0 {
+		cfg.FrontChannelLogoutFrameAncestors = cfg.issuerOrigins()
 	}
 	if cfg.AutoRefreshOnExpiry == nil {
 		cfg.AutoRefreshOnExpiry = configDefaults.AutoRefreshOnExpiry
 	}
+	if cfg.BearerValidation == "" {
+		cfg.BearerValidation = configDefaults.BearerValidation
+	}
+	if cfg.RevokeTokensOnLogout == nil {
+		cfg.RevokeTokensOnLogout = configDefaults.RevokeTokensOnLogout
+	}
 
 	return cfg
 }
 
+// the origins of the provider issuers, in a stable order
+func (cfg *Config) issuerOrigins() []string {
+	issuers := []string{cfg.Issuer}
+	for _, providerConfig := range cfg.AdditionalProviders {
+		issuers = append(issuers, providerConfig.Issuer)
+	}
+	origins := make([]string, 0)
+	for _, issuer := range issuers {
+		u, err := url.Parse(issuer)
+		if err == nil && u.Scheme != "" && u.Host != "" && !slices.Contains(origins, u.Scheme+"://"+u.Host) {
+			origins = append(origins, u.Scheme+"://"+u.Host)
+		}
+	}
+	slices.Sort(origins)
+	return origins
+}
+
+func withProviderDefaults(cfg *provider.OidcProviderConfig) {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = configDefaults.Scopes
+	}
+	if len(cfg.SupportedSigningAlgs) == 0 {
+		cfg.SupportedSigningAlgs = configDefaults.SupportedSigningAlgs
+	}
+	if cfg.UsePKCE == nil {
+		cfg.UsePKCE = configDefaults.UsePKCE
+	}
+}
+
 func (obj *Config) Validate() error {
 	validationErrors := make([]error, 0)
 
@@ -149,6 +522,68 @@ func (obj *Config) Validate() error {
 		}
 	}
 
+	for name, providerConfig := range obj.AdditionalProviders {
+		if name == obj.DefaultProviderName {
+			validationErrors = append(validationErrors, fmt.Errorf("provider %v: name is used by the default provider", name))
+		}
+		if providerConfig.Issuer == "" {
+			validationErrors = append(validationErrors, fmt.Errorf("provider %v: issuer must be specified", name))
+		}
+		if providerConfig.ClientId == "" {
+			validationErrors = append(validationErrors, fmt.Errorf("provider %v: client id must be specified", name))
+		}
+		if providerConfig.ClientSecret == "" {
+			validationErrors = append(validationErrors, fmt.Errorf("provider %v: client secret must be specified", name))
+		}
+		if obj.CallbackPath != "" && !strings.HasSuffix(providerConfig.RedirectUri, obj.CallbackPath) {
+			validationErrors = append(validationErrors, fmt.Errorf("provider %v: callback path must match redirect uri", name))
+		}
+	}
+
+	switch obj.BearerValidation {
+	case BearerValidationJwt, BearerValidationAccessToken, BearerValidationIntrospection, BearerValidationJwtThenIntrospection:
+	default:
+		validationErrors = append(validationErrors, fmt.Errorf("unknown bearer validation: %v", obj.BearerValidation))
+	}
+
+	validationErrors = append(validationErrors, obj.CookiePolicy.validate()...)
+
+	if obj.SessionStorage != nil && (obj.AuthCookieName != "" || obj.AccessTokenCookieName != "" || obj.AuthRefreshCookieName != "" || obj.AuthExpiryCookieName != "" || obj.AuthScopeCookieName != "") {
+		validationErrors = append(validationErrors, errors.New("token cookies can't be used with session storage"))
+	}
+	if obj.AuthExpiryCookieName != "" && obj.CookieCodec == nil {
+		validationErrors = append(validationErrors, errors.New("the auth expiry cookie requires a CookieCodec"))
+	}
+	if obj.AuthScopeCookieName != "" && obj.CookieCodec == nil {
+		validationErrors = append(validationErrors, errors.New("the auth scope cookie requires a CookieCodec"))
+	}
+
+	for _, source := range obj.FrontChannelLogoutFrameAncestors {
+		if source == "" || strings.ContainsAny(source, " \t\r\n;,") {
+			validationErrors = append(validationErrors, fmt.Errorf("invalid front-channel logout frame ancestor: %q", source))
+		}
+	}
+
+	if !isSafeRelativePath(obj.PostLoginFallbackPath) {
+		validationErrors = append(validationErrors, fmt.Errorf("post login fallback path must be a relative path: %v", obj.PostLoginFallbackPath))
+	}
+
+	if obj.PostLogoutRedirectUri != "" {
+		if !strings.HasPrefix(obj.PostLogoutCallbackPath, "/") || !strings.HasSuffix(obj.PostLogoutRedirectUri, obj.PostLogoutCallbackPath) {
+			validationErrors = append(validationErrors, fmt.Errorf("post logout callback path must match post logout redirect uri: %v", obj.PostLogoutCallbackPath))
+		}
+	}
+	if obj.LoginPath != "" && !isSafeRelativePath(obj.LoginPath) {
+		validationErrors = append(validationErrors, fmt.Errorf("login path must be a relative path: %v", obj.LoginPath))
+	}
+	if !isSafeRelativePath(obj.PostLogoutLandingPath) {
+		validationErrors = append(validationErrors, fmt.Errorf("post logout landing path must be a relative path: %v", obj.PostLogoutLandingPath))
+	}
+
+	if len(obj.LoginStateSecret) == 0 {
+		validationErrors = append(validationErrors, errors.New("login state secret must be specified"))
+	}
+
 	if len(validationErrors) == 0 {
 		return nil
 	}
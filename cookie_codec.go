@@ -0,0 +1,98 @@
+package fiberoidc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+// CookieCodec encodes cookie values before they are sent to the browser,
+// and decodes them when they come back.
+// The cookie name is supplied so that a value can be bound to its cookie.
+type CookieCodec interface {
+	Encode(name string, value string) (string, error)
+	Decode(name string, value string) (string, error)
+}
+
+type CookieKey struct {
+	// Identifies the key, and is embedded in every cookie value.
+	// Must not be blank, or contain a '.'
+	Id string
+
+	// 16, 24 or 32 bytes, for AES-128, AES-192 or AES-256
+	Secret []byte
+}
+
+// KeyRing is an AEAD (AES-GCM) CookieCodec.
+// Values are encrypted with the active key, and can be decrypted with any
+// key in the ring, so keys can be rotated without logging anyone out:
+// add a new active key, and keep the old one as decrypt-only until all the
+// cookies it encrypted have expired.
+type KeyRing struct {
+	activeId string
+	aeads    map[string]cipher.AEAD
+}
+
+var _ CookieCodec = (*KeyRing)(nil)
+
+func NewKeyRing(active CookieKey, decryptOnly ...CookieKey) (*KeyRing, error) {
+	keyRing := &KeyRing{
+		activeId: active.Id,
+		aeads:    make(map[string]cipher.AEAD),
+	}
+	for _, key := range append([]CookieKey{active}, decryptOnly...) {
+		if key.Id == "" || strings.Contains(key.Id, ".") {
+			return nil, fmt.Errorf("invalid cookie key id: %q", key.Id)
+		}
+		if _, exists := keyRing.aeads[key.Id]; exists {
+			return nil, fmt.Errorf("duplicate cookie key id: %q", key.Id)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie key %q: %w", key.Id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keyRing.aeads[key.Id] = aead
+	}
+	return keyRing, nil
+}
+
+// Encodes as "keyId.base64(nonce|ciphertext)"
+func (obj *KeyRing) Encode(name string, value string) (string, error) {
+	aead := obj.aeads[obj.activeId]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return obj.activeId + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (obj *KeyRing) Decode(name string, value string) (string, error) {
+	keyId, encoded, found := strings.Cut(value, ".")
+	if !found {
+		return "", provider.ErrCookieDecode
+	}
+	aead, ok := obj.aeads[keyId]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown key id %q", provider.ErrCookieDecode, keyId)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", provider.ErrCookieDecode
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", provider.EnsureErr(err, provider.ErrCookieDecode)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,56 @@
+package fiberoidc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	oldKey := CookieKey{Id: "k1", Secret: bytes.Repeat([]byte{1}, 32)}
+	newKey := CookieKey{Id: "k2", Secret: bytes.Repeat([]byte{2}, 32)}
+
+	oldRing, err := NewKeyRing(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := oldRing.Encode("cookie", "secret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotated, but the old key can still decrypt
+	rotatedRing, err := NewKeyRing(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := rotatedRing.Decode("cookie", encoded)
+	if err != nil || decoded != "secret-token" {
+		t.Fatalf("Unexpected decode: %v %v", decoded, err)
+	}
+	reencoded, err := rotatedRing.Encode("cookie", "secret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = oldRing.Decode("cookie", reencoded); !errors.Is(err, provider.ErrCookieDecode) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// bound to the cookie name
+	if _, err = rotatedRing.Decode("other-cookie", encoded); !errors.Is(err, provider.ErrCookieDecode) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// tampered
+	if _, err = rotatedRing.Decode("cookie", encoded[:len(encoded)-2]); !errors.Is(err, provider.ErrCookieDecode) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = NewKeyRing(CookieKey{Id: "a.b", Secret: oldKey.Secret}); err == nil {
+		t.Fatal("Expected invalid key id")
+	}
+	if _, err = NewKeyRing(CookieKey{Id: "short", Secret: []byte("short")}); err == nil {
+		t.Fatal("Expected invalid key")
+	}
+}
@@ -0,0 +1,322 @@
+package fiberoidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+	"github.com/valyala/fasthttp"
+)
+
+const cookiePrefixHost = "__Host-"
+const cookiePrefixSecure = "__Secure-"
+
+// CookiePolicy is applied to every cookie the middleware sets (or clears)
+type CookiePolicy struct {
+	// OPTIONAL, defaults to true
+	HttpOnly *bool
+
+	// OPTIONAL, defaults to true
+	// N.B. browsers treat http://localhost as secure
+	Secure *bool
+
+	// OPTIONAL, defaults to "Lax"
+	// The login state cookie is never stricter than "Lax", as it must be
+	// sent on the redirect back from the IdP
+	SameSite string
+
+	// OPTIONAL
+	// Share cookies with subdomains (eg: "example.com"), for subdomain SSO.
+	// Can't be used with the "__Host-" prefix
+	Domain string
+
+	// OPTIONAL, defaults to "/"
+	Path string
+
+	// OPTIONAL
+	// "__Host-" or "__Secure-", which is prepended to every cookie name
+	Prefix string
+
+	// OPTIONAL, defaults to 3800
+	// Values longer than this are split across numbered chunk cookies
+	// (name.0, name.1, ...), to stay under the ~4KB browser limit
+	ChunkSize int
+
+	// OPTIONAL
+	// Token cookies expire with the token, unless there is a refresh token
+	// (which usually has no known expiry).
+	// With a refresh token, cookies last for this long, or for the browser
+	// session if unspecified
+	RefreshTokenLifetime time.Duration
+}
+
+func (obj *CookiePolicy) withDefaults() {
+	if obj.HttpOnly == nil {
+		obj.HttpOnly = &boolTrue
+	}
+	if obj.Secure == nil {
+		obj.Secure = &boolTrue
+	}
+	if obj.SameSite == "" {
+		obj.SameSite = fiber.CookieSameSiteLaxMode
+	}
+	if obj.Path == "" {
+		obj.Path = "/"
+	}
+	if obj.ChunkSize <= 0 {
+		obj.ChunkSize = 3800
+	}
+}
+
+func (obj *CookiePolicy) validate() []error {
+	validationErrors := make([]error, 0)
+	secure := obj.Secure == nil || *obj.Secure
+	switch obj.Prefix {
+	case "":
+	case cookiePrefixHost:
+		if obj.Domain != "" || (obj.Path != "" && obj.Path != "/") {
+			validationErrors = append(validationErrors, errors.New("__Host- cookies must have no domain, and a path of /"))
+		}
+		fallthrough
+	case cookiePrefixSecure:
+		if !secure {
+			validationErrors = append(validationErrors, errors.New("prefixed cookies must be secure"))
+		}
+	default:
+		validationErrors = append(validationErrors, errors.New("cookie prefix must be __Host- or __Secure-"))
+	}
+	if strings.EqualFold(obj.SameSite, fiber.CookieSameSiteNoneMode) && !secure {
+		validationErrors = append(validationErrors, errors.New("SameSite=None cookies must be secure"))
+	}
+	return validationErrors
+}
+
+// the cookie name, as it is on the wire
+func (obj *FiberOidcStruct) cookieName(name string) string {
+	return obj.Config.CookiePolicy.Prefix + name
+}
+
+// a cookie with the policy applied.
+// a zero expiry is a session cookie
+func (obj *FiberOidcStruct) newCookie(name string, value string, expires time.Time) *fiber.Cookie {
+	policy := obj.Config.CookiePolicy
+	return &fiber.Cookie{
+		Name:     obj.cookieName(name),
+		Value:    value,
+		Path:     policy.Path,
+		Domain:   policy.Domain,
+		Expires:  expires,
+		Secure:   policy.Secure == nil || *policy.Secure,
+		HTTPOnly: policy.HttpOnly == nil || *policy.HttpOnly,
+		SameSite: policy.SameSite,
+	}
+}
+
+// readCookie returns the raw cookie value
+func (obj *FiberOidcStruct) readCookie(c *fiber.Ctx, name string) string {
+	return c.Cookies(obj.cookieName(name))
+}
+
+// writeCookie sets the raw cookie value
+func (obj *FiberOidcStruct) writeCookie(c *fiber.Ctx, cookie *fiber.Cookie) {
+	c.Cookie(cookie)
+}
+
+func chunkName(name string, index int) string {
+	return name + "." + strconv.Itoa(index)
+}
+
+// the number of chunk cookies sent with the request
+func (obj *FiberOidcStruct) countChunks(c *fiber.Ctx, name string) int {
+	count := 0
+	for obj.readCookie(c, chunkName(name, count)) != "" {
+		count++
+	}
+	return count
+}
+
+// getCookie returns the (reassembled and decoded) cookie value, or blank
+// if it is not present or can't be decoded
+func (obj *FiberOidcStruct) getCookie(c *fiber.Ctx, name string) string {
+	value := obj.readCookie(c, name)
+	if value == "" {
+		chunks := make([]string, obj.countChunks(c, name))
+		for i := range chunks {
+			chunks[i] = obj.readCookie(c, chunkName(name, i))
+		}
+		value = strings.Join(chunks, "")
+	}
+	if value == "" || obj.Config.CookieCodec == nil {
+		return value
+	}
+	decoded, err := obj.Config.CookieCodec.Decode(obj.cookieName(name), value)
+	if err != nil {
+		// tampered with, or encoded with a key that has been retired
+		return ""
+	}
+	return decoded
+}
+
+// setCookie encodes the value, and splits it into chunks if required.
+// Any stale cookies (or chunks) from the request are cleared
+func (obj *FiberOidcStruct) setCookie(c *fiber.Ctx, name string, value string, expires time.Time) error {
+	if obj.Config.CookieCodec != nil {
+		encoded, err := obj.Config.CookieCodec.Encode(obj.cookieName(name), value)
+		if err != nil {
+			return err
+		}
+		value = encoded
+	}
+
+	existingChunks := obj.countChunks(c, name)
+	chunkSize := obj.Config.CookiePolicy.ChunkSize
+	if chunkSize <= 0 || len(value) <= chunkSize {
+		obj.writeCookie(c, obj.newCookie(name, value, expires))
+		for i := 0; i < existingChunks; i++ {
+			obj.writeCookie(c, obj.newCookie(chunkName(name, i), "", fasthttp.CookieExpireDelete))
+		}
+		return nil
+	}
+
+	chunkCount := 0
+	for ; len(value) > 0; chunkCount++ {
+		chunk := value[:min(chunkSize, len(value))]
+		value = value[len(chunk):]
+		obj.writeCookie(c, obj.newCookie(chunkName(name, chunkCount), chunk, expires))
+	}
+	for i := chunkCount; i < existingChunks; i++ {
+		obj.writeCookie(c, obj.newCookie(chunkName(name, i), "", fasthttp.CookieExpireDelete))
+	}
+	if obj.readCookie(c, name) != "" {
+		obj.writeCookie(c, obj.newCookie(name, "", fasthttp.CookieExpireDelete))
+	}
+	return nil
+}
+
+// clearCookie expires the cookie (and any chunks). It needs the same path
+// and domain that it was set with, or browsers will ignore it
+func (obj *FiberOidcStruct) clearCookie(c *fiber.Ctx, name string) {
+	obj.writeCookie(c, obj.newCookie(name, "", fasthttp.CookieExpireDelete))
+	existingChunks := obj.countChunks(c, name)
+	for i := 0; i < existingChunks; i++ {
+		obj.writeCookie(c, obj.newCookie(chunkName(name, i), "", fasthttp.CookieExpireDelete))
+	}
+}
+
+// when token cookies should expire
+func (obj *FiberOidcStruct) tokenCookieExpiry(userAuth *provider.ProviderAuth, tokenExpiry time.Time) time.Time {
+	if userAuth.RefreshToken == "" {
+		return tokenExpiry
+	}
+	// must outlive the tokens, so that they can be refreshed
+	if obj.Config.CookiePolicy.RefreshTokenLifetime > 0 {
+		return time.Now().Add(obj.Config.CookiePolicy.RefreshTokenLifetime)
+	}
+	return time.Time{}
+}
+
+// identifies the id token that a cookie value is bound to
+func idTokenBinding(rawIdToken string) string {
+	hash := sha256.Sum256([]byte(rawIdToken))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// the expiry, bound to the id token it extends (so it can't be moved to another)
+func expiryCookieValue(expiry time.Time, rawIdToken string) string {
+	return strconv.FormatInt(expiry.Unix(), 10) + "." + idTokenBinding(rawIdToken)
+}
+
+// zero unless the value is for this id token
+func parseExpiryCookieValue(value string, rawIdToken string) time.Time {
+	unixValue, _, found := strings.Cut(value, ".")
+	if !found || rawIdToken == "" {
+		return time.Time{}
+	}
+	unix, err := strconv.ParseInt(unixValue, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	expiry := time.Unix(unix, 0)
+	if subtle.ConstantTimeCompare([]byte(value), []byte(expiryCookieValue(expiry, rawIdToken))) != 1 {
+		return time.Time{}
+	}
+	return expiry
+}
+
+// the granted scopes, bound to the id token they were granted with
+func scopeCookieValue(scopes []string, rawIdToken string) string {
+	return strings.Join(scopes, " ") + "." + idTokenBinding(rawIdToken)
+}
+
+// nil unless the value is for this id token
+func parseScopeCookieValue(value string, rawIdToken string) []string {
+	separator := strings.LastIndex(value, ".")
+	if separator < 0 || rawIdToken == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(value[separator+1:]), []byte(idTokenBinding(rawIdToken))) != 1 {
+		return nil
+	}
+	return strings.Fields(value[:separator])
+}
+
+// set whichever token cookies are configured
+func (obj *FiberOidcStruct) setTokenCookies(c *fiber.Ctx, userAuth *provider.ProviderAuth) error {
+	idTokenExpiry := time.Time{}
+	if idToken := userAuth.GetIdToken(); idToken != nil {
+		idTokenExpiry = idToken.Expiry
+	}
+	accessTokenExpiry := idTokenExpiry
+	if oauth2Token := userAuth.GetOauth2Token(); oauth2Token != nil && !oauth2Token.Expiry.IsZero() {
+		accessTokenExpiry = oauth2Token.Expiry
+	}
+	expiry := ""
+	if !userAuth.Expiry.IsZero() {
+		expiry = expiryCookieValue(userAuth.Expiry, userAuth.RawIdToken)
+	}
+	scope := ""
+	if len(userAuth.Scopes) != 0 {
+		scope = scopeCookieValue(userAuth.Scopes, userAuth.RawIdToken)
+	}
+
+	cookies := []struct {
+		name    string
+		value   string
+		expires time.Time
+	}{
+		{obj.Config.AuthCookieName, userAuth.RawIdToken, obj.tokenCookieExpiry(userAuth, idTokenExpiry)},
+		{obj.Config.AccessTokenCookieName, userAuth.AccessToken, obj.tokenCookieExpiry(userAuth, accessTokenExpiry)},
+		{obj.Config.AuthRefreshCookieName, userAuth.RefreshToken, obj.tokenCookieExpiry(userAuth, time.Time{})},
+		{obj.Config.AuthExpiryCookieName, expiry, obj.tokenCookieExpiry(userAuth, time.Time{})},
+		{obj.Config.AuthScopeCookieName, scope, obj.tokenCookieExpiry(userAuth, idTokenExpiry)},
+	}
+	for _, cookie := range cookies {
+		if cookie.name == "" {
+			continue
+		}
+		if err := obj.setCookie(c, cookie.name, cookie.value, cookie.expires); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (obj *FiberOidcStruct) clearTokenCookies(c *fiber.Ctx) {
+	for _, name := range []string{
+		obj.Config.AuthCookieName,
+		obj.Config.AccessTokenCookieName,
+		obj.Config.AuthRefreshCookieName,
+		obj.Config.AuthExpiryCookieName,
+		obj.Config.AuthScopeCookieName,
+	} {
+		if name != "" {
+			obj.clearCookie(c, name)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package fiberoidc
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCookiePolicy(t *testing.T) {
+	obj := FiberOidcStruct{
+		Config: (&Config{
+			WebAppConfig: WebAppConfig{
+				CookiePolicy: CookiePolicy{
+					Prefix: "__Host-",
+				},
+			},
+		}).WithDefaults(),
+	}
+	if errs := obj.Config.CookiePolicy.validate(); len(errs) != 0 {
+		t.Fatalf("Unexpected validation errors: %v", errs)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	cookie := obj.newCookie("auth", "value", expires)
+	if cookie.Name != "__Host-auth" || !cookie.Secure || !cookie.HTTPOnly || cookie.Path != "/" || cookie.Domain != "" {
+		t.Fatalf("Unexpected cookie: %v", cookie)
+	}
+	if cookie.SameSite != fiber.CookieSameSiteLaxMode || !cookie.Expires.Equal(expires) {
+		t.Fatalf("Unexpected cookie: %v", cookie)
+	}
+
+	// login state is never strict
+	obj.Config.CookiePolicy.SameSite = fiber.CookieSameSiteStrictMode
+	if cookie = obj.loginStateCookie("value", expires); cookie.SameSite != fiber.CookieSameSiteLaxMode {
+		t.Fatalf("Unexpected login state cookie: %v", cookie)
+	}
+
+	insecure := false
+	invalid := CookiePolicy{
+		Prefix: "__Host-",
+		Domain: "example.com",
+		Secure: &insecure,
+	}
+	if errs := invalid.validate(); len(errs) != 2 {
+		t.Fatalf("Unexpected validation errors: %v", errs)
+	}
+}
+
+// runs the handler, with the request cookies, and returns the response cookies
+func execCookieHandler(t *testing.T, requestCookies []*http.Cookie, handler fiber.Handler) map[string]*http.Cookie {
+	app := fiber.New()
+	app.Get("/", handler)
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	for _, cookie := range requestCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range resp.Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func TestCookieChunking(t *testing.T) {
+	obj := FiberOidcStruct{
+		Config: (&Config{
+			WebAppConfig: WebAppConfig{
+				CookiePolicy: CookiePolicy{
+					ChunkSize: 100,
+				},
+			},
+		}).WithDefaults(),
+	}
+	largeValue := strings.Repeat("x", 250)
+
+	chunks := execCookieHandler(t, nil, func(c *fiber.Ctx) error {
+		return obj.setCookie(c, "auth", largeValue, time.Time{})
+	})
+	if len(chunks) != 3 || len(chunks["auth.0"].Value) != 100 || len(chunks["auth.2"].Value) != 50 {
+		t.Fatalf("Unexpected chunks: %v", chunks)
+	}
+
+	requestCookies := []*http.Cookie{chunks["auth.0"], chunks["auth.1"], chunks["auth.2"]}
+	execCookieHandler(t, requestCookies, func(c *fiber.Ctx) error {
+		if value := obj.getCookie(c, "auth"); value != largeValue {
+			t.Fatalf("Unexpected value: %v", value)
+		}
+		return nil
+	})
+
+	// shrinking clears the stale chunks
+	cookies := execCookieHandler(t, requestCookies, func(c *fiber.Ctx) error {
+		return obj.setCookie(c, "auth", "small", time.Time{})
+	})
+	if cookies["auth"].Value != "small" || len(cookies) != 4 {
+		t.Fatalf("Unexpected cookies: %v", cookies)
+	}
+	for _, name := range []string{"auth.0", "auth.1", "auth.2"} {
+		if cookies[name].Value != "" || cookies[name].Expires.After(time.Now()) {
+			t.Fatalf("Unexpected stale chunk: %v", cookies[name])
+		}
+	}
+}
+
+func TestAuthExpiryCookie(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	defer server.Close()
+	idp := newTestIdP(t, "corporate")
+	idp.config.StaticMetadata.TokenEndpoint = server.URL
+	claims := idp.claims("alice")
+	claims["exp"] = time.Now().Add(-47 * time.Hour).Unix()
+	expiredIdToken := idp.sign(t, claims)
+	otherIdToken := idp.sign(t, idp.claims("bob"))
+	farFuture := time.Now().Add(24 * time.Hour)
+
+	newApp := func(codec CookieCodec) (*FiberOidcStruct, *fiber.App) {
+		config := &Config{OidcProviderConfig: idp.config}
+		config.RedirectUri = "http://localhost:3000/oauth2/callback"
+		config.AuthCookieName = "auth"
+		config.AuthRefreshCookieName = "refresh"
+		config.CookieCodec = codec
+		fiberOidc, err := New(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app := fiber.New()
+		app.Get("/protected", fiberOidc.ProtectedRoute(), func(c *fiber.Ctx) error {
+			return c.SendString("ok")
+		})
+		return fiberOidc.(*FiberOidcStruct), app
+	}
+	protected := func(obj *FiberOidcStruct, app *fiber.App, cookies map[string]string) int {
+		req := httptest.NewRequest(fiber.MethodGet, "/protected", nil)
+		for name, value := range cookies {
+			if obj.Config.CookieCodec != nil {
+				encoded, err := obj.Config.CookieCodec.Encode(obj.cookieName(name), value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				value = encoded
+			}
+			req.AddCookie(&http.Cookie{Name: obj.cookieName(name), Value: value})
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// without a codec, there is no expiry cookie to forge
+	obj, app := newApp(nil)
+	if obj.Config.AuthExpiryCookieName != "" {
+		t.Fatalf("Unexpected expiry cookie: %v", obj.Config.AuthExpiryCookieName)
+	}
+	status := protected(obj, app, map[string]string{
+		"auth":           expiredIdToken,
+		"refresh":        "junk",
+		"refresh-expiry": "99999999999",
+	})
+	if status != fiber.StatusFound {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+	explicit := (&Config{OidcProviderConfig: idp.config}).WithDefaults()
+	explicit.RedirectUri = "http://localhost:3000/oauth2/callback"
+	explicit.AuthExpiryCookieName = "expiry"
+	if err := explicit.Validate(); err == nil {
+		t.Fatal("Expected the expiry cookie to require a CookieCodec")
+	}
+
+	keyRing, err := NewKeyRing(CookieKey{Id: "k1", Secret: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, app = newApp(keyRing)
+	if obj.Config.AuthExpiryCookieName != "refresh-expiry" {
+		t.Fatalf("Unexpected expiry cookie: %v", obj.Config.AuthExpiryCookieName)
+	}
+	status = protected(obj, app, map[string]string{
+		"auth":           expiredIdToken,
+		"refresh":        "refresh-token",
+		"refresh-expiry": expiryCookieValue(farFuture, expiredIdToken),
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+	// an expiry for a different id token doesn't extend this one
+	status = protected(obj, app, map[string]string{
+		"auth":           expiredIdToken,
+		"refresh":        "refresh-token",
+		"refresh-expiry": expiryCookieValue(farFuture, otherIdToken),
+	})
+	if status != fiber.StatusFound {
+		t.Fatalf("Unexpected status: %v", status)
+	}
+}
+
+func TestAuthScopeCookie(t *testing.T) {
+	value := scopeCookieValue([]string{"openid", "orders.read"}, "id-token")
+	if scopes := parseScopeCookieValue(value, "id-token"); len(scopes) != 2 || scopes[1] != "orders.read" {
+		t.Fatalf("Unexpected scopes: %v", scopes)
+	}
+	// bound to the id token
+	if scopes := parseScopeCookieValue(value, "other-id-token"); scopes != nil {
+		t.Fatalf("Unexpected scopes: %v", scopes)
+	}
+
+	config := (&Config{WebAppConfig: WebAppConfig{AuthCookieName: "auth"}}).WithDefaults()
+	if config.AuthScopeCookieName != "" {
+		t.Fatalf("Unexpected scope cookie without a CookieCodec: %v", config.AuthScopeCookieName)
+	}
+}
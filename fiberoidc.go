@@ -3,6 +3,10 @@ package fiberoidc
 import (
 	"context"
 	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	gooidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gofiber/fiber/v2"
@@ -16,8 +20,16 @@ type fiberOidcAuthLocalsKey struct{}
 // direct access to fields, if you need to tweak or override something
 // which should, of course, be entirely unnessesary
 type FiberOidcStruct struct {
-	Config        *Config
+	Config *Config
+
+	// the default provider
 	OidcProviders *provider.OidcProviders
+
+	// every provider, including the default
+	Registry *provider.Registry
+
+	// serializes back-channel logout replay checks
+	backChannelMutex sync.Mutex
 }
 
 type FiberOidc interface {
@@ -25,6 +37,11 @@ type FiberOidc interface {
 	// Will redirect if required
 	ProtectedRoute() fiber.Handler
 
+	// Protects an API route (as a resource server), with a bearer token
+	// that has all of the scopes (if any).
+	// Never redirects, and responds with RFC 6750 errors instead
+	BearerRoute(scopes ...string) fiber.Handler
+
 	// Does not protect the route, but will still bind any valid
 	// auth token to the request
 	UnprotectedRoute() fiber.Handler
@@ -35,6 +52,37 @@ type FiberOidc interface {
 	// easy access to the callback path
 	CallbackPath() string
 
+	// Explicitly starts a login, eg: from a "Sign in" link on a public page.
+	// Accepts return_to, provider, login_hint and prompt query parameters, and
+	// shows the ProviderChooser if there is more than one provider
+	LoginHandler() fiber.Handler
+
+	// Logs out, by clearing the tokens (or session), and then redirecting to
+	// the IdP end_session_endpoint (if there is one) to end the IdP session
+	LogoutHandler() fiber.Handler
+
+	// Handles the redirect back from the IdP after logout, if there is a
+	// PostLogoutRedirectUri
+	PostLogoutCallbackHandler() fiber.Handler
+
+	// easy access to the post logout callback path
+	PostLogoutCallbackPath() string
+
+	// Receives back-channel logout tokens from the IdP (mount on a POST),
+	// and revokes the sessions they identify
+	BackChannelLogoutHandler() fiber.Handler
+
+	// Loaded by the IdP in an iframe, to clear the (matching) session.
+	// N.B. the cookies need SameSite=None to be sent to an iframe
+	FrontChannelLogoutHandler() fiber.Handler
+
+	// Ends a server side session, and revokes its tokens at the IdP
+	KillSession(ctx context.Context, sessionId string) error
+
+	// Starts a login, by redirecting to the IdP
+	// eg: to retry with a different prompt from a LoginErrorHandler
+	RedirectToLogin(c *fiber.Ctx, opts LoginOptions) error
+
 	Providers() provider.Providers
 }
 
@@ -47,12 +95,59 @@ func New(ctx context.Context, config *Config) (FiberOidc, error) {
 		return nil, err
 	}
 
-	return &FiberOidcStruct{
-		Config: config,
-		OidcProviders: &provider.OidcProviders{
-			OidcProviderConfig: config.OidcProviderConfig,
-		},
-	}, nil
+	defaultProvider := &provider.OidcProviders{
+		OidcProviderConfig: config.OidcProviderConfig,
+	}
+	additionalProviders := make(map[string]*provider.OidcProviders)
+	for name, providerConfig := range config.AdditionalProviders {
+		additionalProviders[name] = &provider.OidcProviders{
+			OidcProviderConfig: providerConfig,
+		}
+	}
+	registry, err := provider.NewRegistry(config.DefaultProviderName, defaultProvider, additionalProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	// before any background work is started, so that a failure leaves nothing running
+	if config.EagerInitTimeout > 0 {
+		initCtx, cancel := context.WithTimeout(ctx, config.EagerInitTimeout)
+		defer cancel()
+		err = registry.Initialize(initCtx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.LogoutStorage == nil {
+		if config.SessionStorage != nil {
+			config.LogoutStorage = config.SessionStorage
+		} else {
+			storage := NewMemoryStorage(time.Minute)
+			config.LogoutStorage = storage
+			go func() {
+				<-ctx.Done()
+				_ = storage.Close()
+			}()
+		}
+	}
+	if config.RevocationList == nil {
+		config.RevocationList = &StorageRevocationList{
+			Storage: config.LogoutStorage,
+			TTL:     config.RevocationTTL,
+		}
+	}
+
+	obj := &FiberOidcStruct{
+		Config:        config,
+		OidcProviders: defaultProvider,
+		Registry:      registry,
+	}
+
+	// runs until the context is done, for each provider with a DiscoveryRefreshInterval
+	obj.Registry.RefreshPeriodically(ctx)
+
+	return obj, nil
 }
 
 func (obj *FiberOidcStruct) ProtectedRoute() fiber.Handler {
@@ -72,22 +167,39 @@ func (obj *FiberOidcStruct) CallbackPath() string {
 }
 
 func (obj *FiberOidcStruct) Providers() provider.Providers {
-	return obj.OidcProviders
+	return obj.Registry
 }
 
-func (obj *FiberOidcStruct) getAuthToken(c *fiber.Ctx) string {
-	// Get authorization header
+// the token from the 'Authorization: Bearer' header, if any
+func bearerToken(c *fiber.Ctx) string {
 	auth := c.Get(fiber.HeaderAuthorization)
 	if len(auth) > 7 && utils.EqualFold(auth[:7], "bearer ") {
 		return auth[7:]
 	}
+	return ""
+}
+
+// the raw ID token, from the bearer header or the id token cookie
+func (obj *FiberOidcStruct) getIdToken(c *fiber.Ctx) string {
+	if bearer := bearerToken(c); bearer != "" {
+		return bearer
+	}
 
 	// if its empty, fallback to 'authcookiename' (if not blank)
-	if auth == "" && obj.Config.AuthCookieName != "" {
-		return c.Cookies(obj.Config.AuthCookieName)
+	if c.Get(fiber.HeaderAuthorization) == "" && obj.Config.AuthCookieName != "" {
+		return obj.getCookie(c, obj.Config.AuthCookieName)
+	}
+	return ""
+}
+
+// the access token, which is only carried in a cookie
+func (obj *FiberOidcStruct) getAccessToken(c *fiber.Ctx) string {
+	if obj.Config.AccessTokenCookieName != "" {
+		return obj.getCookie(c, obj.Config.AccessTokenCookieName)
 	}
 	return ""
 }
+
 func (obj *FiberOidcStruct) getRefreshToken(c *fiber.Ctx) string {
 	// Get authorization header
 	refreshToken := c.Get("Authorization-Refresh") // shouldn't _really_ be sent.
@@ -97,76 +209,284 @@ func (obj *FiberOidcStruct) getRefreshToken(c *fiber.Ctx) string {
 
 	// if its empty, fallback to 'refreshcookiename' (if not blank)
 	if refreshToken == "" && obj.Config.AuthRefreshCookieName != "" {
-		return c.Cookies(obj.Config.AuthRefreshCookieName)
+		return obj.getCookie(c, obj.Config.AuthRefreshCookieName)
 	}
 	return ""
 }
 
+// when a refresh without a new id token expires, from the expiry cookie
+// (which must be for this id token)
+func (obj *FiberOidcStruct) getTokenExpiry(c *fiber.Ctx, rawIdToken string) time.Time {
+	if bearerToken(c) != "" || obj.Config.AuthExpiryCookieName == "" || obj.Config.CookieCodec == nil {
+		return time.Time{}
+	}
+	return parseExpiryCookieValue(obj.getCookie(c, obj.Config.AuthExpiryCookieName), rawIdToken)
+}
+
+// the granted scopes, from the scope cookie (which must be for this id token)
+func (obj *FiberOidcStruct) getTokenScopes(c *fiber.Ctx, rawIdToken string) []string {
+	if bearerToken(c) != "" || obj.Config.AuthScopeCookieName == "" || obj.Config.CookieCodec == nil {
+		return nil
+	}
+	return parseScopeCookieValue(obj.getCookie(c, obj.Config.AuthScopeCookieName), rawIdToken)
+}
+
+// the tokens for a request, and where they came from
+type requestTokens struct {
+	idToken      string
+	accessToken  string
+	refreshToken string
+
+	// see ProviderAuth.Expiry
+	expiry time.Time
+
+	// see ProviderAuth.Scopes
+	scopes []string
+
+	// set when the tokens are from the SessionStorage
+	sessionId string
+
+	// set when the token is from the 'Authorization: Bearer' header
+	bearer bool
+}
+
+// a bearer header always takes precedence, otherwise tokens come from the
+// SessionStorage (in session mode) or from cookies
+func (obj *FiberOidcStruct) getRequestTokens(c *fiber.Ctx, withRefreshToken bool) (*requestTokens, error) {
+	if !obj.sessionMode() || c.Get(fiber.HeaderAuthorization) != "" {
+		tokens := &requestTokens{
+			idToken:     obj.getIdToken(c),
+			accessToken: obj.getAccessToken(c),
+			bearer:      bearerToken(c) != "",
+		}
+		tokens.scopes = obj.getTokenScopes(c, tokens.idToken)
+		if withRefreshToken {
+			tokens.refreshToken = obj.getRefreshToken(c)
+			tokens.expiry = obj.getTokenExpiry(c, tokens.idToken)
+		}
+		return tokens, nil
+	}
+
+	sessionId, data, err := obj.loadSession(c)
+	if err != nil {
+		return nil, err
+	}
+	tokens := &requestTokens{
+		sessionId: sessionId,
+	}
+	if data != nil {
+		tokens.idToken = data.IdToken
+		tokens.accessToken = data.AccessToken
+		tokens.scopes = data.Scopes
+		if withRefreshToken {
+			tokens.refreshToken = data.RefreshToken
+			tokens.expiry = fromUnixTime(data.Expiry)
+		}
+	}
+	return tokens, nil
+}
+
+// write (refreshed) tokens back to wherever they came from
+func (obj *FiberOidcStruct) updateTokens(c *fiber.Ctx, tokens *requestTokens, userAuth *provider.ProviderAuth) error {
+	if tokens.sessionId != "" {
+		_, err := obj.saveSession(c, tokens.sessionId, userAuth)
+		return err
+	}
+	if obj.sessionMode() {
+		// from a bearer header, there is nowhere to write them back to
+		return nil
+	}
+	return obj.setTokenCookies(c, userAuth)
+}
+
+func (obj *FiberOidcStruct) clearTokens(c *fiber.Ctx, tokens *requestTokens) error {
+	if tokens.sessionId != "" {
+		return obj.deleteSession(c, tokens.sessionId)
+	}
+	if !obj.sessionMode() {
+		obj.clearTokenCookies(c)
+	}
+	return nil
+}
+
 func (obj *FiberOidcStruct) handleOAuth2Callback(c *fiber.Ctx) error {
 	ctx := c.Context()
 
 	//c.Query() doesn't seem to work.
 	queries := c.Queries()
-	state := queries["state"]
 	code := queries["code"]
 
-	oauth2Config, err := obj.OidcProviders.Oauth2Config(ctx)
+	// not a response from the IdP, but a login url (see LoginPath)
+	if queries["state"] == "" && code == "" && queries["error"] == "" {
+		return obj.handleLogin(c)
+	}
+
+	// the state must be the one bound to this browser, even for an error,
+	// so that a crafted callback link can't trigger the LoginErrorHandler
+	state, err := obj.completeLogin(c, queries["state"])
+	if err != nil {
+		return err
+	}
+
+	// the IdP may have returned an error instead of a code
+	if queries["error"] != "" || code == "" {
+		callbackError := &provider.CallbackError{
+			Code:        queries["error"],
+			Description: queries["error_description"],
+			URI:         queries["error_uri"],
+		}
+		if callbackError.Code == "" {
+			callbackError.Code = provider.CallbackErrorInvalidRequest
+			callbackError.Description = "no authorization code"
+		}
+		return obj.Config.LoginErrorHandler(callbackError, state.AppState, c)
+	}
+
+	// the provider the login was started with
+	providers, err := obj.Registry.Get(state.Provider)
 	if err != nil {
 		return err
 	}
-	oauth2Token, err := oauth2Config.Exchange(ctx, code)
+
+	oauth2Token, err := providers.Exchange(ctx, code, state.exchangeOptions()...)
 	if err != nil {
 		return err
 	}
 
-	userAuth, err := obj.OidcProviders.ValidateJwt(ctx, oauth2Token.AccessToken, oauth2Token.RefreshToken)
+	// the id token must be for this login (no replays)
+	userAuth, err := providers.ValidateTokenResponse(ctx, oauth2Token, state.Nonce)
 	if err != nil {
 		return err
 	}
 	c.Locals(fiberOidcAuthLocalsKey{}, userAuth)
 
-	// also set it into a cookie if configured to do so
-	if obj.Config.AuthCookieName != "" {
-		c.Cookie(&fiber.Cookie{
-			Name:  obj.Config.AuthCookieName,
-			Value: oauth2Token.AccessToken,
-		})
+	if obj.sessionMode() {
+		// always a new session id on login (no session fixation)
+		existingSessionId := obj.getCookie(c, obj.Config.SessionCookieName)
+		if existingSessionId != "" {
+			err = obj.Config.SessionStorage.Delete(sessionStorageKey(existingSessionId))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = obj.saveSession(c, "", userAuth)
+	} else {
+		// also set it into cookies if configured to do so
+		err = obj.setTokenCookies(c, userAuth)
 	}
-	if obj.Config.AuthRefreshCookieName != "" {
-		c.Cookie(&fiber.Cookie{
-			Name:  obj.Config.AuthRefreshCookieName,
-			Value: oauth2Token.RefreshToken,
-		})
+	if err != nil {
+		return err
 	}
+
 	// complete, use *FromContext to access user details
-	return obj.Config.LoginSuccessHandler(state, c)
+	return obj.Config.LoginSuccessHandler(state.AppState, c)
 }
 
 func (obj *FiberOidcStruct) doAuthRequiredRedirect(c *fiber.Ctx) error {
-	state, err := obj.Config.LoginStateEncoder(c)
+	appState, err := obj.Config.LoginStateEncoder(c)
 	if err != nil {
 		return err
 	}
+	opts := LoginOptions{
+		AppState: appState,
+	}
 
-	oauth2Config, err := obj.OidcProviders.Oauth2Config(c.Context())
+	// a redirect to the IdP is no use to an XHR/fetch request
+	c.Vary(fiber.HeaderAccept, fiber.HeaderXRequestedWith, "Sec-Fetch-Mode", "Sec-Fetch-Dest")
+	if obj.Config.IsNavigational(c) {
+		return obj.RedirectToLogin(c, opts)
+	}
+	// the login state is only created when the user navigates to the login url,
+	// so that concurrent requests don't overwrite each others state
+	loginPath := obj.Config.LoginPath
+	if loginPath == "" {
+		loginPath = obj.Config.CallbackPath
+	}
+	loginUrlState, err := obj.encodeLoginUrlState(appState)
+	if err != nil {
+		return err
+	}
+	loginUrl := loginPath + "?" + url.Values{"app_state": {loginUrlState}}.Encode()
+	return obj.Config.LoginRequiredHandler(loginUrl, c)
+}
+
+func (obj *FiberOidcStruct) RedirectToLogin(c *fiber.Ctx, opts LoginOptions) error {
+	loginUrl, err := obj.startLoginUrl(c, opts)
 	if err != nil {
 		return err
 	}
 
 	// V3 Redirect (for later)
 	// return c.Redirect().To(cfg.OidcConfig.AuthCodeURL(""))
-	return c.Redirect(oauth2Config.AuthCodeURL(state), 302)
+	return c.Redirect(loginUrl, 302)
+}
+
+// starts a login, and returns the url at the IdP to continue it
+func (obj *FiberOidcStruct) startLoginUrl(c *fiber.Ctx, opts LoginOptions) (string, error) {
+	providers, err := obj.Registry.Get(opts.Provider)
+	if err != nil {
+		return "", err
+	}
+
+	usePkce := providers.OidcProviderConfig.UsePKCE != nil && *providers.OidcProviderConfig.UsePKCE
+	state, err := obj.startLogin(c, opts.Provider, opts.AppState, usePkce)
+	if err != nil {
+		return "", err
+	}
+
+	oauth2Config, err := providers.Oauth2Config(c.Context())
+	if err != nil {
+		return "", err
+	}
+	return oauth2Config.AuthCodeURL(state.State, append(state.authCodeOptions(), opts.authCodeOptions()...)...), nil
+}
+
+// bearer tokens are validated according to the BearerValidation,
+// everything else is an id token
+func (obj *FiberOidcStruct) validateTokens(ctx context.Context, tokens *requestTokens) (*provider.ProviderAuth, error) {
+	if !tokens.bearer {
+		return obj.Registry.ValidateTokens(ctx, tokens.idToken, (&oauth2.Token{
+			AccessToken:  tokens.accessToken,
+			RefreshToken: tokens.refreshToken,
+			Expiry:       tokens.expiry,
+		}).WithExtra(map[string]interface{}{
+			"scope": strings.Join(tokens.scopes, " "),
+		}))
+	}
+	switch obj.Config.BearerValidation {
+	case BearerValidationAccessToken:
+		return obj.Registry.ValidateAccessToken(ctx, tokens.idToken)
+	case BearerValidationIntrospection:
+		return obj.Registry.IntrospectToken(ctx, tokens.idToken)
+	case BearerValidationJwtThenIntrospection:
+		userAuth, err := obj.Registry.ValidateJwt(ctx, tokens.idToken, tokens.accessToken, tokens.refreshToken)
+		if err != nil && errors.Is(err, provider.ErrNotAuthorized) {
+			// eg: not a jwt
+			return obj.Registry.IntrospectToken(ctx, tokens.idToken)
+		}
+		return userAuth, err
+	}
+	return obj.Registry.ValidateJwt(ctx, tokens.idToken, tokens.accessToken, tokens.refreshToken)
 }
 
 func (obj *FiberOidcStruct) protectedRouteHandler(protectedRoute bool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		// for the Require handlers
+		c.Locals(fiberOidcConfigLocalsKey{}, obj.Config)
+
 		ctx := c.Context()
-		accessToken := obj.getAuthToken(c)
-		refreshToken := ""
-		if *obj.Config.AutoRefreshOnExpiry {
-			refreshToken = obj.getRefreshToken(c)
+		tokens, err := obj.getRequestTokens(c, *obj.Config.AutoRefreshOnExpiry)
+		if err != nil {
+			return err
 		}
-		if accessToken == "" {
+		if tokens.idToken == "" {
+			// eg: a session cookie for an expired session
+			if tokens.sessionId != "" {
+				err = obj.clearTokens(c, tokens)
+				if err != nil {
+					return err
+				}
+			}
 			if protectedRoute {
 				return obj.doAuthRequiredRedirect(c)
 			} else {
@@ -174,33 +494,50 @@ func (obj *FiberOidcStruct) protectedRouteHandler(protectedRoute bool) fiber.Han
 			}
 		}
 
-		userAuth, err := obj.OidcProviders.ValidateJwt(ctx, accessToken, refreshToken)
+		userAuth, err := obj.validateTokens(ctx, tokens)
+		if userAuth != nil && userAuth.GetIdToken() != nil && obj.Config.RevocationList != nil {
+			// logged out at the IdP
+			revoked, err := obj.Config.RevocationList.IsRevoked(userAuth.GetIdToken())
+			if err != nil {
+				return err
+			}
+			if revoked {
+				err = obj.clearTokens(c, tokens)
+				if err != nil {
+					return err
+				}
+				if protectedRoute {
+					return obj.doAuthRequiredRedirect(c)
+				}
+				return c.Next()
+			}
+		}
 		if protectedRoute && err != nil {
 			if errors.Is(err, provider.ErrTokenExpired) {
+				err = obj.clearTokens(c, tokens)
+				if err != nil {
+					return err
+				}
 				return obj.doAuthRequiredRedirect(c)
 			}
 			return err
 		}
 		if userAuth != nil {
-			if userAuth.GetOauth2Token().AccessToken != accessToken && obj.Config.AuthCookieName != "" {
-				c.Cookie(&fiber.Cookie{
-					Name:  obj.Config.AuthCookieName,
-					Value: userAuth.GetOauth2Token().AccessToken,
-				})
+			// tokens only change on refresh (and introspection has no id token)
+			if userAuth.GetIdToken() != nil && (userAuth.RawIdToken != tokens.idToken || userAuth.AccessToken != tokens.accessToken || userAuth.RefreshToken != tokens.refreshToken) {
+				err = obj.updateTokens(c, tokens, userAuth)
+				if err != nil {
+					return err
+				}
 			}
-			if refreshToken != "" && userAuth.GetOauth2Token().RefreshToken != refreshToken && obj.Config.AuthRefreshCookieName != "" {
-				c.Cookie(&fiber.Cookie{
-					Name:  obj.Config.AuthRefreshCookieName,
-					Value: userAuth.GetOauth2Token().RefreshToken,
-				})
+			if tokens.sessionId != "" {
+				c.Locals(fiberOidcSessionLocalsKey{}, tokens.sessionId)
 			}
 			c.Locals(fiberOidcAuthLocalsKey{}, userAuth)
-		} else {
-			if obj.Config.AuthCookieName != "" {
-				c.ClearCookie(obj.Config.AuthCookieName)
-			}
-			if obj.Config.AuthRefreshCookieName != "" {
-				c.ClearCookie(obj.Config.AuthRefreshCookieName)
+		} else if sessionEnded(err) {
+			err = obj.clearTokens(c, tokens)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -208,6 +545,14 @@ func (obj *FiberOidcStruct) protectedRouteHandler(protectedRoute bool) fiber.Han
 	}
 }
 
+// an expired or invalid session, rather than a failure (eg: initialization,
+// or the token endpoint being unavailable) that the session may outlive
+func sessionEnded(err error) bool {
+	return err == nil || errors.Is(err, provider.ErrTokenExpired) ||
+		errors.Is(err, provider.ErrNotAuthorized) || errors.Is(err, provider.ErrNoAuth) ||
+		errors.Is(err, provider.ErrTokenIdentityMismatch)
+}
+
 func ProviderAuth(c *fiber.Ctx) *provider.ProviderAuth {
 	userAuth, ok := c.Locals(fiberOidcAuthLocalsKey{}).(*provider.ProviderAuth)
 	if !ok {
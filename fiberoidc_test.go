@@ -1,11 +1,16 @@
 package fiberoidc
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/kncept/fiber-oidc/provider"
 	"github.com/valyala/fasthttp"
 )
 
@@ -57,7 +62,7 @@ func TestGetAuth(t *testing.T) {
 		"",
 		nil,
 		func(c *fiber.Ctx) error {
-			token := obj.getAuthToken(c)
+			token := obj.getIdToken(c)
 			if token != "" {
 				t.Fatalf("Unexpected Auth Token: %v", token)
 			}
@@ -72,7 +77,7 @@ func TestGetAuth(t *testing.T) {
 			authCookieName: authCookieValue,
 		},
 		func(c *fiber.Ctx) error {
-			token := obj.getAuthToken(c)
+			token := obj.getIdToken(c)
 			if token != "" {
 				t.Fatalf("Unexpected Auth Token: %v", token)
 			}
@@ -86,7 +91,7 @@ func TestGetAuth(t *testing.T) {
 			authCookieName: authCookieValue,
 		},
 		func(c *fiber.Ctx) error {
-			token := obj.getAuthToken(c)
+			token := obj.getIdToken(c)
 			if token != authHeaderValue {
 				t.Fatalf("Unexpected Auth Token: %v", token)
 			}
@@ -109,7 +114,7 @@ func TestGetAuth(t *testing.T) {
 		},
 		func(c *fiber.Ctx) error {
 
-			token := obj.getAuthToken(c)
+			token := obj.getIdToken(c)
 			if token != authHeaderValue {
 				t.Fatalf("Unexpected Auth Token: %v", token)
 			}
@@ -126,7 +131,7 @@ func TestGetAuth(t *testing.T) {
 		},
 		func(c *fiber.Ctx) error {
 
-			token := obj.getAuthToken(c)
+			token := obj.getIdToken(c)
 			if token != authCookieValue {
 				t.Fatalf("Unexpected Auth Token: %v", token)
 			}
@@ -143,7 +148,7 @@ func TestGetAuth(t *testing.T) {
 		},
 		func(c *fiber.Ctx) error {
 
-			token := obj.getAuthToken(c)
+			token := obj.getIdToken(c)
 			if token != "" {
 				t.Fatalf("Unexpected Auth Token: %v", token)
 			}
@@ -151,3 +156,60 @@ func TestGetAuth(t *testing.T) {
 		},
 	)
 }
+
+func TestCallbackError(t *testing.T) {
+	obj := &FiberOidcStruct{
+		Config: (&Config{}).WithDefaults(),
+	}
+	state, err := newLoginState("", "/some/path", time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := obj.encodeLoginState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := func(query string) *http.Response {
+		app := fiber.New()
+		app.Get("/callback", obj.CallbackHandler())
+		req := httptest.NewRequest(fiber.MethodGet, "/callback?state="+state.State+"&"+query, nil)
+		req.AddCookie(&http.Cookie{Name: obj.Config.LoginStateCookieName, Value: value})
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := callback("error=access_denied&error_description=cancelled")
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("Unexpected status: %v", resp.StatusCode)
+	}
+
+	var callbackError *provider.CallbackError
+	appState := ""
+	obj.Config.LoginErrorHandler = func(err *provider.CallbackError, state string, c *fiber.Ctx) error {
+		callbackError = err
+		appState = state
+		return c.Redirect("/", fiber.StatusFound)
+	}
+	resp = callback("error=login_required&error_uri=https%3A%2F%2Fidp%2Ferror")
+	if resp.StatusCode != fiber.StatusFound || !errors.Is(callbackError, provider.ErrCallback) || appState != "/some/path" {
+		t.Fatalf("Unexpected response: %v %v %v", resp.StatusCode, callbackError, appState)
+	}
+	if callbackError.Code != provider.CallbackErrorLoginRequired || callbackError.URI != "https://idp/error" {
+		t.Fatalf("Unexpected callback error: %v", callbackError)
+	}
+
+	// without the login state, the handler is never called
+	callbackError = nil
+	app := fiber.New()
+	app.Get("/callback", obj.CallbackHandler())
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/callback?error=login_required", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == fiber.StatusFound || callbackError != nil {
+		t.Fatalf("Unexpected response: %v %v", resp.StatusCode, callbackError)
+	}
+}
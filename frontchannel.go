@@ -0,0 +1,65 @@
+package fiberoidc
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func (obj *FiberOidcStruct) FrontChannelLogoutHandler() fiber.Handler {
+	return obj.handleFrontChannelLogout
+}
+
+// the sources that are allowed to frame the front-channel logout
+func (obj *FiberOidcStruct) frameAncestors() string {
+	if len(obj.Config.FrontChannelLogoutFrameAncestors) == 0 {
+		return "'none'"
+	}
+	return strings.Join(obj.Config.FrontChannelLogoutFrameAncestors, " ")
+}
+
+// loaded by the IdP (in an iframe) to log out of this app
+// see https://openid.net/specs/openid-connect-frontchannel-1_0.html#RPLogout
+func (obj *FiberOidcStruct) handleFrontChannelLogout(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-cache, no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+	c.Set(fiber.HeaderContentSecurityPolicy, "frame-ancestors "+obj.frameAncestors())
+	c.Response().Header.Del(fiber.HeaderXFrameOptions)
+
+	issuer := c.Query("iss")
+	sessionId := c.Query("sid")
+	if obj.Config.FrontChannelLogoutSessionRequired && (issuer == "" || sessionId == "") {
+		return fiber.NewError(fiber.StatusBadRequest, "iss and sid are required")
+	}
+
+	tokens, err := obj.getRequestTokens(c, false)
+	if err != nil {
+		return err
+	}
+	if tokens.idToken != "" && (issuer != "" || sessionId != "") {
+		// only log out the session the IdP asked for.
+		// The id token came from this app, so it doesn't need to be verified
+		// (or be unexpired) to match it
+		claims := struct {
+			Issuer    string `json:"iss"`
+			SessionId string `json:"sid"`
+		}{}
+		err = provider.UnverifiedClaims(tokens.idToken, &claims)
+		if err != nil || (issuer != "" && claims.Issuer != issuer) || (sessionId != "" && claims.SessionId != sessionId) {
+			return obj.frontChannelLogoutResponse(c)
+		}
+	}
+	if tokens.idToken != "" || tokens.sessionId != "" {
+		err = obj.clearTokens(c, tokens)
+		if err != nil {
+			return err
+		}
+	}
+	return obj.frontChannelLogoutResponse(c)
+}
+
+func (obj *FiberOidcStruct) frontChannelLogoutResponse(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString("<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Logged out</title></head><body></body></html>")
+}
@@ -0,0 +1,86 @@
+package fiberoidc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFrontChannelLogout(t *testing.T) {
+	config := &Config{OidcProviderConfig: staticProviderConfig("corporate")}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.AuthCookieName = "auth"
+	config.FrontChannelLogoutSessionRequired = true
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/frontchannel-logout", fiberOidc.FrontChannelLogoutHandler())
+	idToken := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://corporate.example.com","sid":"s1"}`)) + ".sig"
+	exec := func(target string) (*http.Response, bool) {
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		req.AddCookie(&http.Cookie{Name: "auth", Value: idToken})
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "auth" && cookie.Value == "" {
+				return resp, true
+			}
+		}
+		return resp, false
+	}
+
+	resp, cleared := exec("/frontchannel-logout?iss=https://corporate.example.com&sid=other")
+	if resp.StatusCode != fiber.StatusOK || cleared {
+		t.Fatalf("Expected a different session to be left alone: %v %v", resp.StatusCode, cleared)
+	}
+	if resp.Header.Get(fiber.HeaderContentSecurityPolicy) != "frame-ancestors https://corporate.example.com" || resp.Header.Get(fiber.HeaderCacheControl) != "no-cache, no-store" {
+		t.Fatalf("Unexpected headers: %v", resp.Header)
+	}
+
+	resp, cleared = exec("/frontchannel-logout?iss=https://corporate.example.com&sid=s1")
+	if resp.StatusCode != fiber.StatusOK || !cleared {
+		t.Fatalf("Expected the session to be cleared: %v %v", resp.StatusCode, cleared)
+	}
+
+	resp, cleared = exec("/frontchannel-logout")
+	if resp.StatusCode != fiber.StatusBadRequest || cleared {
+		t.Fatalf("Expected iss and sid to be required: %v %v", resp.StatusCode, cleared)
+	}
+}
+
+func TestFrontChannelLogoutFrameAncestors(t *testing.T) {
+	config := (&Config{OidcProviderConfig: staticProviderConfig("corporate")}).WithDefaults()
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	if len(config.FrontChannelLogoutFrameAncestors) != 1 || config.FrontChannelLogoutFrameAncestors[0] != "https://corporate.example.com" {
+		t.Fatalf("Unexpected default frame ancestors: %v", config.FrontChannelLogoutFrameAncestors)
+	}
+
+	config.FrontChannelLogoutFrameAncestors = []string{"https://login.corporate.example.com"}
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/frontchannel-logout", fiberOidc.FrontChannelLogoutHandler())
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/frontchannel-logout", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get(fiber.HeaderContentSecurityPolicy) != "frame-ancestors https://login.corporate.example.com" {
+		t.Fatalf("Unexpected headers: %v", resp.Header)
+	}
+
+	config.FrontChannelLogoutFrameAncestors = []string{"https://a.example.com; script-src *"}
+	if err = config.Validate(); err == nil {
+		t.Fatal("Expected an invalid frame ancestor")
+	}
+}
@@ -0,0 +1,62 @@
+package fiberoidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBearerValidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"active": r.PostForm.Get("token") == "opaque-token",
+			"sub":    "api-client",
+		})
+	}))
+	defer server.Close()
+
+	idp := newTestIdP(t, "corporate")
+	idp.config.StaticMetadata.IntrospectionEndpoint = server.URL + "/introspect"
+	idp.config.AccessTokenAudiences = []string{"https://api.example.com"}
+	idToken := idp.sign(t, idp.claims("alice"))
+	accessTokenClaims := idp.claims("alice")
+	accessTokenClaims["aud"] = "https://api.example.com"
+	accessToken := idp.sign(t, accessTokenClaims)
+
+	for mode, expected := range map[BearerValidation]map[string]int{
+		BearerValidationJwt:                  {idToken: fiber.StatusOK, accessToken: fiber.StatusInternalServerError, "opaque-token": fiber.StatusInternalServerError},
+		BearerValidationAccessToken:          {idToken: fiber.StatusInternalServerError, accessToken: fiber.StatusOK},
+		BearerValidationIntrospection:        {idToken: fiber.StatusInternalServerError, "opaque-token": fiber.StatusOK},
+		BearerValidationJwtThenIntrospection: {idToken: fiber.StatusOK, "opaque-token": fiber.StatusOK, "other-token": fiber.StatusInternalServerError},
+	} {
+		config := &Config{OidcProviderConfig: idp.config}
+		config.RedirectUri = "http://localhost:3000/oauth2/callback"
+		config.BearerValidation = mode
+		fiberOidc, err := New(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app := fiber.New()
+		app.Get("/api", fiberOidc.ProtectedRoute(), func(c *fiber.Ctx) error {
+			return c.SendString(ProviderAuth(c).Subject)
+		})
+
+		for token, status := range expected {
+			req := httptest.NewRequest(fiber.MethodGet, "/api", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != status {
+				t.Fatalf("%v: Unexpected status for %.10v: %v", mode, token, resp.StatusCode)
+			}
+		}
+	}
+}
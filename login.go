@@ -0,0 +1,113 @@
+package fiberoidc
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProviderChoice is one of the options on the provider chooser
+type ProviderChoice struct {
+	// the provider (registry) name
+	Name string
+
+	// the LoginHandler url for this provider, with the rest of the
+	// login parameters carried along
+	LoginUrl string
+}
+
+var defaultProviderChooserTemplate = template.Must(template.New("provider-chooser").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in with</h1>
+<ul>
+{{range .}}<li><a href="{{.LoginUrl}}">{{.Name}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// the OIDC prompt values, which may be combined (space separated)
+var validPrompts = map[string]bool{
+	"none":           true,
+	"login":          true,
+	"consent":        true,
+	"select_account": true,
+}
+
+func isValidPrompt(prompt string) bool {
+	for _, value := range strings.Fields(prompt) {
+		if !validPrompts[value] {
+			return false
+		}
+	}
+	return true
+}
+
+func (obj *FiberOidcStruct) LoginHandler() fiber.Handler {
+	return obj.handleLogin
+}
+
+// starts a login from the query parameters:
+// return_to (relative path, or allowlisted url), app_state (from the login url
+// for programmatic requests), provider, login_hint and prompt.
+// Without a provider, shows the provider chooser if there is more than one
+func (obj *FiberOidcStruct) handleLogin(c *fiber.Ctx) error {
+	queries := c.Queries()
+	opts := LoginOptions{
+		Provider:  queries["provider"],
+		LoginHint: queries["login_hint"],
+		Prompt:    queries["prompt"],
+		// the default LoginSuccessHandler checks this again
+		AppState: obj.Config.safeRedirectTarget(queries["return_to"]),
+	}
+	if !isValidPrompt(opts.Prompt) {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid prompt")
+	}
+	// a return_to (eg: the page an SPA is on) takes precedence over
+	// the app state of the request that needed the login
+	if queries["return_to"] == "" && queries["app_state"] != "" {
+		appState, err := obj.decodeLoginUrlState(queries["app_state"])
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		opts.AppState = appState
+	}
+
+	names := obj.Registry.Names()
+	if opts.Provider == "" && len(names) > 1 {
+		return obj.Config.ProviderChooser(obj.providerChoices(c, names), c)
+	}
+	if _, err := obj.Registry.Get(opts.Provider); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return obj.RedirectToLogin(c, opts)
+}
+
+func (obj *FiberOidcStruct) providerChoices(c *fiber.Ctx, names []string) []ProviderChoice {
+	choices := make([]ProviderChoice, 0, len(names))
+	for _, name := range names {
+		query := url.Values{}
+		query.Set("provider", name)
+		for _, param := range []string{"return_to", "app_state", "login_hint", "prompt"} {
+			if value := c.Query(param); value != "" {
+				query.Set(param, value)
+			}
+		}
+		choices = append(choices, ProviderChoice{
+			Name:     name,
+			LoginUrl: c.Path() + "?" + query.Encode(),
+		})
+	}
+	return choices
+}
+
+// renders the ProviderChooserTemplate
+func (cfg *WebAppConfig) renderProviderChooser(choices []ProviderChoice, c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return cfg.ProviderChooserTemplate.Execute(c, choices)
+}
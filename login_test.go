@@ -0,0 +1,88 @@
+package fiberoidc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func staticProviderConfig(name string) provider.OidcProviderConfig {
+	return provider.OidcProviderConfig{
+		Issuer:       "https://" + name + ".example.com",
+		ClientId:     name + "-client",
+		ClientSecret: name + "-secret",
+		StaticMetadata: &provider.StaticMetadata{
+			ProviderMetadata: provider.ProviderMetadata{
+				AuthorizationEndpoint: "https://" + name + ".example.com/authorize",
+				TokenEndpoint:         "https://" + name + ".example.com/token",
+				JwksUri:               "https://" + name + ".example.com/jwks",
+			},
+		},
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	config := &Config{
+		OidcProviderConfig:  staticProviderConfig("corporate"),
+		AdditionalProviders: map[string]provider.OidcProviderConfig{"google": staticProviderConfig("google")},
+	}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/login", fiberOidc.LoginHandler())
+	exec := func(target string) *http.Response {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := exec("/login?return_to=/account")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusOK || !strings.Contains(string(body), "/login?provider=google&amp;return_to=%2Faccount") {
+		t.Fatalf("Unexpected chooser: %v %s", resp.StatusCode, body)
+	}
+
+	resp = exec("/login?provider=google&return_to=https://evil.com&prompt=select_account&login_hint=me@example.com")
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("Unexpected status: %v", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Host != "google.example.com" || location.Query().Get("prompt") != "select_account" || location.Query().Get("login_hint") != "me@example.com" {
+		t.Fatalf("Unexpected redirect: %v", location)
+	}
+	obj := fiberOidc.(*FiberOidcStruct)
+	var state *loginState
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == config.LoginStateCookieName {
+			state, err = obj.decodeLoginState(cookie.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if state == nil || state.Provider != "google" || state.AppState != "/" {
+		t.Fatalf("Unexpected login state: %+v", state)
+	}
+
+	for _, target := range []string{"/login?provider=missing", "/login?provider=google&prompt=bogus"} {
+		if resp = exec(target); resp.StatusCode != fiber.StatusBadRequest {
+			t.Fatalf("Unexpected status for %v: %v", target, resp.StatusCode)
+		}
+	}
+}
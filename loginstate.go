@@ -0,0 +1,243 @@
+package fiberoidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/oauth2"
+)
+
+// LoginOptions for starting a login
+type LoginOptions struct {
+	// OPTIONAL
+	// the name of the provider to log in with, defaults to the default provider
+	Provider string
+
+	// OPTIONAL
+	// handed to the LoginSuccessHandler (or LoginErrorHandler)
+	AppState string
+
+	// OPTIONAL
+	// OIDC prompt parameter, eg: "login", "consent", "select_account" or "none"
+	Prompt string
+
+	// OPTIONAL
+	// OIDC login_hint parameter, eg: an email address
+	LoginHint string
+}
+
+func (obj LoginOptions) authCodeOptions() []oauth2.AuthCodeOption {
+	opts := make([]oauth2.AuthCodeOption, 0)
+	if obj.Prompt != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", obj.Prompt))
+	}
+	if obj.LoginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", obj.LoginHint))
+	}
+	return opts
+}
+
+// loginState is bound to the browser (in an encrypted cookie) for the
+// duration of the login redirect round trip.
+// The State value is what is sent to the IdP, the AppState is what the
+// LoginStateEncoder produced, and is only ever handed back on success.
+// The (PKCE) Verifier never leaves the browser/server round trip.
+// The Nonce must come back in the id token.
+// The Provider is the (registry) name of the provider, blank for the default.
+type loginState struct {
+	State    string `json:"s"`
+	Provider string `json:"p,omitempty"`
+	AppState string `json:"a,omitempty"`
+	Verifier string `json:"v,omitempty"`
+	Nonce    string `json:"n"`
+	Expiry   int64  `json:"e"`
+}
+
+func randomString(byteLength int) (string, error) {
+	b := make([]byte, byteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newLoginState(providerName string, appState string, ttl time.Duration, usePkce bool) (*loginState, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier := ""
+	if usePkce {
+		verifier = oauth2.GenerateVerifier()
+	}
+	return &loginState{
+		State:    state,
+		Provider: providerName,
+		AppState: appState,
+		Verifier: verifier,
+		Nonce:    nonce,
+		Expiry:   time.Now().Add(ttl).Unix(),
+	}, nil
+}
+
+// options for the auth code url (redirect to the IdP)
+func (obj *loginState) authCodeOptions() []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{
+		gooidc.Nonce(obj.Nonce),
+	}
+	if obj.Verifier != "" {
+		opts = append(opts, oauth2.S256ChallengeOption(obj.Verifier))
+	}
+	return opts
+}
+
+// options for the code exchange (callback)
+func (obj *loginState) exchangeOptions() []oauth2.AuthCodeOption {
+	opts := make([]oauth2.AuthCodeOption, 0)
+	if obj.Verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(obj.Verifier))
+	}
+	return opts
+}
+
+// the CookieCodec, if configured, so that key rotation applies here as well
+func (obj *FiberOidcStruct) loginStateCodec() (CookieCodec, error) {
+	if obj.Config.CookieCodec != nil {
+		return obj.Config.CookieCodec, nil
+	}
+	key := sha256.Sum256(obj.Config.LoginStateSecret)
+	return NewKeyRing(CookieKey{Id: "ls", Secret: key[:]})
+}
+
+func (obj *FiberOidcStruct) encodeLoginState(state *loginState) (string, error) {
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	codec, err := obj.loginStateCodec()
+	if err != nil {
+		return "", err
+	}
+	return codec.Encode(obj.cookieName(obj.Config.LoginStateCookieName), string(plaintext))
+}
+
+func (obj *FiberOidcStruct) decodeLoginState(value string) (*loginState, error) {
+	codec, err := obj.loginStateCodec()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := codec.Decode(obj.cookieName(obj.Config.LoginStateCookieName), value)
+	if err != nil {
+		return nil, provider.EnsureErr(err, provider.ErrInvalidLoginState)
+	}
+	state := &loginState{}
+	if err = json.Unmarshal([]byte(plaintext), state); err != nil {
+		return nil, provider.EnsureErr(err, provider.ErrInvalidLoginState)
+	}
+	return state, nil
+}
+
+// the app state on the login url for programmatic requests.
+// It is the LoginStateEncoder output, so it is carried as is (not filtered
+// like a return_to), and is encrypted and authenticated instead
+type loginUrlState struct {
+	AppState string `json:"s"`
+	Expiry   int64  `json:"e"`
+}
+
+func (obj *FiberOidcStruct) encodeLoginUrlState(appState string) (string, error) {
+	plaintext, err := json.Marshal(&loginUrlState{
+		AppState: appState,
+		Expiry:   time.Now().Add(obj.Config.LoginStateTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	codec, err := obj.loginStateCodec()
+	if err != nil {
+		return "", err
+	}
+	return codec.Encode(obj.Config.LoginStateCookieName+"-app-state", string(plaintext))
+}
+
+func (obj *FiberOidcStruct) decodeLoginUrlState(value string) (string, error) {
+	codec, err := obj.loginStateCodec()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := codec.Decode(obj.Config.LoginStateCookieName+"-app-state", value)
+	if err != nil {
+		return "", provider.EnsureErr(err, provider.ErrInvalidLoginState)
+	}
+	state := &loginUrlState{}
+	if err = json.Unmarshal([]byte(plaintext), state); err != nil {
+		return "", provider.EnsureErr(err, provider.ErrInvalidLoginState)
+	}
+	if time.Now().Unix() > state.Expiry {
+		return "", provider.ErrLoginStateExpired
+	}
+	return state.AppState, nil
+}
+
+// state cookies follow the cookie policy, but must be sent on the
+// (cross site) redirect back from the IdP
+func (obj *FiberOidcStruct) redirectStateCookie(name string, value string, expires time.Time) *fiber.Cookie {
+	cookie := obj.newCookie(name, value, expires)
+	if strings.EqualFold(cookie.SameSite, fiber.CookieSameSiteStrictMode) {
+		cookie.SameSite = fiber.CookieSameSiteLaxMode
+	}
+	return cookie
+}
+
+func (obj *FiberOidcStruct) loginStateCookie(value string, expires time.Time) *fiber.Cookie {
+	return obj.redirectStateCookie(obj.Config.LoginStateCookieName, value, expires)
+}
+
+// bind a new login state to the browser, and return it
+func (obj *FiberOidcStruct) startLogin(c *fiber.Ctx, providerName string, appState string, usePkce bool) (*loginState, error) {
+	state, err := newLoginState(providerName, appState, obj.Config.LoginStateTTL, usePkce)
+	if err != nil {
+		return nil, err
+	}
+	value, err := obj.encodeLoginState(state)
+	if err != nil {
+		return nil, err
+	}
+	obj.writeCookie(c, obj.loginStateCookie(value, time.Unix(state.Expiry, 0)))
+	return state, nil
+}
+
+// recover the login state bound to the browser, and check it against
+// the state returned from the IdP.
+// The login state cookie is single use, and is always cleared.
+func (obj *FiberOidcStruct) completeLogin(c *fiber.Ctx, returnedState string) (*loginState, error) {
+	value := obj.readCookie(c, obj.Config.LoginStateCookieName)
+	obj.writeCookie(c, obj.loginStateCookie("", fasthttp.CookieExpireDelete))
+	if value == "" {
+		return nil, provider.ErrLoginStateMissing
+	}
+	state, err := obj.decodeLoginState(value)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > state.Expiry {
+		return nil, provider.ErrLoginStateExpired
+	}
+	if returnedState == "" || subtle.ConstantTimeCompare([]byte(state.State), []byte(returnedState)) != 1 {
+		return nil, provider.ErrLoginStateMismatch
+	}
+	return state, nil
+}
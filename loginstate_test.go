@@ -0,0 +1,86 @@
+package fiberoidc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func TestLoginStateRoundTrip(t *testing.T) {
+	obj := FiberOidcStruct{
+		Config: (&Config{}).WithDefaults(),
+	}
+
+	state, err := newLoginState("", "/some/path", time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := obj.encodeLoginState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := map[string]string{
+		obj.Config.LoginStateCookieName: value,
+	}
+
+	execVirtualHandler("/", "", cookies, func(c *fiber.Ctx) error {
+		recovered, err := obj.completeLogin(c, state.State)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if recovered.AppState != "/some/path" {
+			t.Fatalf("Unexpected App State: %v", recovered.AppState)
+		}
+		if recovered.Verifier == "" || recovered.Verifier != state.Verifier {
+			t.Fatalf("Unexpected PKCE Verifier: %v", recovered.Verifier)
+		}
+		return nil
+	})
+
+	execVirtualHandler("/", "", cookies, func(c *fiber.Ctx) error {
+		_, err := obj.completeLogin(c, "forged")
+		if !errors.Is(err, provider.ErrLoginStateMismatch) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return nil
+	})
+
+	execVirtualHandler("/", "", nil, func(c *fiber.Ctx) error {
+		_, err := obj.completeLogin(c, state.State)
+		if !errors.Is(err, provider.ErrLoginStateMissing) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return nil
+	})
+
+	// tampered (or from a different secret)
+	other := FiberOidcStruct{
+		Config: (&Config{}).WithDefaults(),
+	}
+	execVirtualHandler("/", "", cookies, func(c *fiber.Ctx) error {
+		_, err := other.completeLogin(c, state.State)
+		if !errors.Is(err, provider.ErrInvalidLoginState) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return nil
+	})
+
+	expired, err := newLoginState("", "", -time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err = obj.encodeLoginState(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execVirtualHandler("/", "", map[string]string{obj.Config.LoginStateCookieName: value}, func(c *fiber.Ctx) error {
+		_, err := obj.completeLogin(c, expired.State)
+		if !errors.Is(err, provider.ErrLoginStateExpired) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return nil
+	})
+}
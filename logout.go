@@ -0,0 +1,139 @@
+package fiberoidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+	"github.com/valyala/fasthttp"
+)
+
+// logoutState is bound to the browser (in an encrypted cookie) for the
+// duration of the end session redirect round trip
+type logoutState struct {
+	State  string `json:"s"`
+	Expiry int64  `json:"e"`
+}
+
+func (obj *FiberOidcStruct) LogoutHandler() fiber.Handler {
+	return obj.handleLogout
+}
+
+func (obj *FiberOidcStruct) PostLogoutCallbackHandler() fiber.Handler {
+	return obj.handlePostLogoutCallback
+}
+
+func (obj *FiberOidcStruct) PostLogoutCallbackPath() string {
+	return obj.Config.PostLogoutCallbackPath
+}
+
+// clears the local tokens (or session), then ends the session at the IdP
+// if it supports RP-initiated logout
+// see https://openid.net/specs/openid-connect-rpinitiated-1_0.html
+func (obj *FiberOidcStruct) handleLogout(c *fiber.Ctx) error {
+	tokens, err := obj.getRequestTokens(c, true)
+	if err != nil {
+		return err
+	}
+	err = obj.clearTokens(c, tokens)
+	if err != nil {
+		return err
+	}
+
+	// the (possibly expired) id token identifies the provider, and the IdP session
+	providers := obj.tokenProviders(tokens.idToken)
+	if *obj.Config.RevokeTokensOnLogout {
+		// best effort, the local tokens are already gone (see KillSession)
+		_ = obj.revokeTokens(c.Context(), providers, tokens)
+	}
+	metadata, err := providers.Metadata(c.Context())
+	if err != nil {
+		return err
+	}
+	if metadata.EndSessionEndpoint == "" {
+		return c.Redirect(obj.Config.PostLogoutLandingPath, fiber.StatusFound)
+	}
+
+	endSessionUrl, err := url.Parse(metadata.EndSessionEndpoint)
+	if err != nil {
+		return err
+	}
+	query := endSessionUrl.Query()
+	query.Set("client_id", providers.OidcProviderConfig.ClientId)
+	if tokens.idToken != "" {
+		query.Set("id_token_hint", tokens.idToken)
+	}
+	if obj.Config.PostLogoutRedirectUri != "" {
+		state, err := obj.startLogout(c)
+		if err != nil {
+			return err
+		}
+		query.Set("post_logout_redirect_uri", obj.Config.PostLogoutRedirectUri)
+		query.Set("state", state)
+	}
+	endSessionUrl.RawQuery = query.Encode()
+	return c.Redirect(endSessionUrl.String(), fiber.StatusFound)
+}
+
+// bind a new logout state to the browser, and return the state value
+func (obj *FiberOidcStruct) startLogout(c *fiber.Ctx) (string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	expiry := time.Now().Add(obj.Config.LoginStateTTL)
+	plaintext, err := json.Marshal(&logoutState{
+		State:  state,
+		Expiry: expiry.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	codec, err := obj.loginStateCodec()
+	if err != nil {
+		return "", err
+	}
+	value, err := codec.Encode(obj.cookieName(obj.Config.LogoutStateCookieName), string(plaintext))
+	if err != nil {
+		return "", err
+	}
+	obj.writeCookie(c, obj.redirectStateCookie(obj.Config.LogoutStateCookieName, value, expiry))
+	return state, nil
+}
+
+// check the state returned from the IdP against the one bound to the browser.
+// The logout state cookie is single use, and is always cleared.
+func (obj *FiberOidcStruct) completeLogout(c *fiber.Ctx, returnedState string) error {
+	value := obj.readCookie(c, obj.Config.LogoutStateCookieName)
+	obj.writeCookie(c, obj.redirectStateCookie(obj.Config.LogoutStateCookieName, "", fasthttp.CookieExpireDelete))
+	if value == "" {
+		return provider.ErrInvalidLogoutState
+	}
+	codec, err := obj.loginStateCodec()
+	if err != nil {
+		return err
+	}
+	plaintext, err := codec.Decode(obj.cookieName(obj.Config.LogoutStateCookieName), value)
+	if err != nil {
+		return provider.EnsureErr(err, provider.ErrInvalidLogoutState)
+	}
+	state := &logoutState{}
+	if err = json.Unmarshal([]byte(plaintext), state); err != nil {
+		return provider.EnsureErr(err, provider.ErrInvalidLogoutState)
+	}
+	if time.Now().Unix() > state.Expiry || returnedState == "" || subtle.ConstantTimeCompare([]byte(state.State), []byte(returnedState)) != 1 {
+		return provider.ErrInvalidLogoutState
+	}
+	return nil
+}
+
+func (obj *FiberOidcStruct) handlePostLogoutCallback(c *fiber.Ctx) error {
+	err := obj.completeLogout(c, c.Query("state"))
+	if err != nil {
+		return err
+	}
+	return c.Redirect(obj.Config.PostLogoutLandingPath, fiber.StatusFound)
+}
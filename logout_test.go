@@ -0,0 +1,96 @@
+package fiberoidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func TestLogout(t *testing.T) {
+	google := staticProviderConfig("google")
+	google.StaticMetadata.EndSessionEndpoint = "https://google.example.com/logout"
+	config := &Config{
+		OidcProviderConfig:  staticProviderConfig("corporate"),
+		AdditionalProviders: map[string]provider.OidcProviderConfig{"google": google},
+	}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.PostLogoutRedirectUri = "http://localhost:3000/logout/callback"
+	config.PostLogoutLandingPath = "/goodbye"
+	config.AuthCookieName = "auth"
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/logout", fiberOidc.LogoutHandler())
+	app.Get(fiberOidc.PostLogoutCallbackPath(), fiberOidc.PostLogoutCallbackHandler())
+	exec := func(target string, cookies ...*http.Cookie) *http.Response {
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// only the issuer is needed to pick the provider
+	idToken := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://google.example.com"}`)) + ".sig"
+	resp := exec("/logout", &http.Cookie{Name: "auth", Value: idToken})
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if resp.StatusCode != fiber.StatusFound || location.Host != "google.example.com" || query.Get("id_token_hint") != idToken ||
+		query.Get("client_id") != "google-client" || query.Get("post_logout_redirect_uri") != config.PostLogoutRedirectUri {
+		t.Fatalf("Unexpected redirect: %v", location)
+	}
+	var logoutStateCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "auth":
+			if cookie.Value != "" {
+				t.Fatalf("Expected the auth cookie to be cleared: %v", cookie)
+			}
+		case config.LogoutStateCookieName:
+			logoutStateCookie = cookie
+		}
+	}
+	if logoutStateCookie == nil {
+		t.Fatal("Expected a logout state cookie")
+	}
+
+	resp = exec("/logout/callback?state=forged", logoutStateCookie)
+	if resp.StatusCode == fiber.StatusFound {
+		t.Fatal("Expected a forged state to be rejected")
+	}
+	resp = exec("/logout/callback?state="+url.QueryEscape(query.Get("state")), logoutStateCookie)
+	if resp.StatusCode != fiber.StatusFound || resp.Header.Get(fiber.HeaderLocation) != "/goodbye" {
+		t.Fatalf("Unexpected response: %v %v", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+
+	// the default provider has no end_session_endpoint
+	resp = exec("/logout")
+	if resp.StatusCode != fiber.StatusFound || resp.Header.Get(fiber.HeaderLocation) != "/goodbye" {
+		t.Fatalf("Unexpected response: %v %v", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+
+	obj := fiberOidc.(*FiberOidcStruct)
+	execVirtualHandler("/", "", nil, func(c *fiber.Ctx) error {
+		if err := obj.completeLogout(c, "state"); !errors.Is(err, provider.ErrInvalidLogoutState) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return nil
+	})
+}
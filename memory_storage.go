@@ -0,0 +1,108 @@
+package fiberoidc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type memoryStorageEntry struct {
+	value  []byte
+	expiry time.Time // zero for no expiry
+}
+
+func (obj memoryStorageEntry) expired(now time.Time) bool {
+	return !obj.expiry.IsZero() && now.After(obj.expiry)
+}
+
+// MemoryStorage is an in-memory fiber.Storage, with TTL eviction.
+// It is only suitable for a single instance.
+type MemoryStorage struct {
+	mutex   sync.RWMutex
+	entries map[string]memoryStorageEntry
+	done    chan struct{}
+	once    sync.Once
+}
+
+var _ fiber.Storage = (*MemoryStorage)(nil)
+
+// NewMemoryStorage evicts expired entries every gcInterval
+// (expired entries are never returned, regardless of the interval)
+func NewMemoryStorage(gcInterval time.Duration) *MemoryStorage {
+	if gcInterval <= 0 {
+		gcInterval = time.Minute
+	}
+	obj := &MemoryStorage{
+		entries: make(map[string]memoryStorageEntry),
+		done:    make(chan struct{}),
+	}
+	go obj.gc(gcInterval)
+	return obj
+}
+
+func (obj *MemoryStorage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-obj.done:
+			return
+		case now := <-ticker.C:
+			obj.mutex.Lock()
+			for key, entry := range obj.entries {
+				if entry.expired(now) {
+					delete(obj.entries, key)
+				}
+			}
+			obj.mutex.Unlock()
+		}
+	}
+}
+
+func (obj *MemoryStorage) Get(key string) ([]byte, error) {
+	obj.mutex.RLock()
+	entry, ok := obj.entries[key]
+	obj.mutex.RUnlock()
+	if !ok || entry.expired(time.Now()) {
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+func (obj *MemoryStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	entry := memoryStorageEntry{
+		value: append([]byte(nil), val...),
+	}
+	if exp > 0 {
+		entry.expiry = time.Now().Add(exp)
+	}
+	obj.mutex.Lock()
+	obj.entries[key] = entry
+	obj.mutex.Unlock()
+	return nil
+}
+
+func (obj *MemoryStorage) Delete(key string) error {
+	obj.mutex.Lock()
+	delete(obj.entries, key)
+	obj.mutex.Unlock()
+	return nil
+}
+
+func (obj *MemoryStorage) Reset() error {
+	obj.mutex.Lock()
+	obj.entries = make(map[string]memoryStorageEntry)
+	obj.mutex.Unlock()
+	return nil
+}
+
+func (obj *MemoryStorage) Close() error {
+	obj.once.Do(func() {
+		close(obj.done)
+	})
+	return nil
+}
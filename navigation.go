@@ -0,0 +1,45 @@
+package fiberoidc
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isNavigationalRequest is true for a browser navigation (which can be
+// redirected to the IdP), and false for a programmatic (XHR/fetch) request.
+// Fetch metadata is used where the browser sends it, otherwise X-Requested-With,
+// the method and the Accept header
+func isNavigationalRequest(c *fiber.Ctx) bool {
+	if strings.EqualFold(c.Get(fiber.HeaderXRequestedWith), "XMLHttpRequest") {
+		return false
+	}
+	if mode := c.Get("Sec-Fetch-Mode"); mode != "" {
+		return strings.EqualFold(mode, "navigate")
+	}
+	switch strings.ToLower(c.Get("Sec-Fetch-Dest")) {
+	case "":
+	case "document", "iframe", "frame", "embed", "object":
+		return true
+	default:
+		return false
+	}
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return false
+	}
+	accept := c.Get(fiber.HeaderAccept)
+	if accept == "" || strings.Contains(accept, fiber.MIMETextHTML) || strings.Contains(accept, "application/xhtml+xml") {
+		return true
+	}
+	// eg: application/json, without html
+	return !strings.Contains(accept, "json") && !strings.Contains(accept, "text/event-stream")
+}
+
+// default LoginRequiredHandler
+func sendLoginRequired(loginUrl string, c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error":     "login_required",
+		"login_url": loginUrl,
+	})
+}
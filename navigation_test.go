@@ -0,0 +1,169 @@
+package fiberoidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIsNavigationalRequest(t *testing.T) {
+	for name, test := range map[string]struct {
+		method       string
+		headers      map[string]string
+		navigational bool
+	}{
+		"browser":          {fiber.MethodGet, map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"}, true},
+		"no headers":       {fiber.MethodGet, nil, true},
+		"fetch navigation": {fiber.MethodGet, map[string]string{"Sec-Fetch-Mode": "navigate", "Sec-Fetch-Dest": "document"}, true},
+		"fetch":            {fiber.MethodGet, map[string]string{"Sec-Fetch-Mode": "cors", "Accept": "*/*"}, false},
+		"fetch dest":       {fiber.MethodGet, map[string]string{"Sec-Fetch-Dest": "empty"}, false},
+		"xhr":              {fiber.MethodGet, map[string]string{"X-Requested-With": "XMLHttpRequest", "Accept": "text/html"}, false},
+		"json":             {fiber.MethodGet, map[string]string{"Accept": "application/json"}, false},
+		"post":             {fiber.MethodPost, map[string]string{"Accept": "text/html"}, false},
+	} {
+		app := fiber.New()
+		app.Add(test.method, "/", func(c *fiber.Ctx) error {
+			if isNavigationalRequest(c) != test.navigational {
+				t.Errorf("%v: expected navigational=%v", name, test.navigational)
+			}
+			return nil
+		})
+		req := httptest.NewRequest(test.method, "/", nil)
+		for header, value := range test.headers {
+			req.Header.Set(header, value)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProgrammaticLoginRequired(t *testing.T) {
+	for _, loginPath := range []string{"", "/login"} {
+		config := &Config{OidcProviderConfig: staticProviderConfig("corporate")}
+		config.RedirectUri = "http://localhost:3000/oauth2/callback"
+		config.LoginPath = loginPath
+		fiberOidc, err := New(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := fiberOidc.(*FiberOidcStruct)
+		app := fiber.New()
+		app.Get(fiberOidc.CallbackPath(), fiberOidc.CallbackHandler())
+		app.Get("/login", fiberOidc.LoginHandler())
+		app.Get("/page", fiberOidc.ProtectedRoute(), func(c *fiber.Ctx) error {
+			return c.SendString("ok")
+		})
+
+		req := httptest.NewRequest(fiber.MethodGet, "/page", nil)
+		req.Header.Set("Sec-Fetch-Mode", "cors")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := map[string]string{}
+		if err = json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != fiber.StatusUnauthorized || body["error"] != "login_required" {
+			t.Fatalf("Unexpected response: %v %v %v", resp.StatusCode, body, err)
+		}
+		expectedPath := loginPath
+		if expectedPath == "" {
+			expectedPath = fiberOidc.CallbackPath()
+		}
+		loginUrl, err := url.Parse(body["login_url"])
+		if err != nil || loginUrl.Path != expectedPath || loginUrl.Query().Get("app_state") == "" {
+			t.Fatalf("Unexpected login url: %v", body["login_url"])
+		}
+		// no login state until the user navigates to it
+		if len(resp.Cookies()) != 0 {
+			t.Fatalf("Unexpected cookies: %v", resp.Cookies())
+		}
+
+		if appState := startProgrammaticLogin(t, obj, app, body["login_url"]); appState != "/page" {
+			t.Fatalf("Unexpected app state: %v", appState)
+		}
+		// the SPA can come back to its own page instead
+		if appState := startProgrammaticLogin(t, obj, app, body["login_url"]+"&return_to=%2Fspa%2Fcart"); appState != "/spa/cart" {
+			t.Fatalf("Unexpected app state: %v", appState)
+		}
+
+		req = httptest.NewRequest(fiber.MethodGet, "/page", nil)
+		req.Header.Set("Sec-Fetch-Mode", "navigate")
+		if resp, err = app.Test(req); err != nil || resp.StatusCode != fiber.StatusFound {
+			t.Fatalf("Unexpected response: %v %v", resp.StatusCode, err)
+		}
+	}
+}
+
+// follows the login url, and returns the app state in the login state
+func startProgrammaticLogin(t *testing.T, obj *FiberOidcStruct, app *fiber.App, loginUrl string) string {
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, loginUrl, nil))
+	if err != nil || resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("Unexpected response: %v %v", resp.StatusCode, err)
+	}
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil || location.Host != "corporate.example.com" {
+		t.Fatalf("Unexpected login redirect: %v", location)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == obj.Config.LoginStateCookieName {
+			state, err := obj.decodeLoginState(cookie.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return state.AppState
+		}
+	}
+	t.Fatal("Expected a login state cookie")
+	return ""
+}
+
+func TestProgrammaticLoginAppState(t *testing.T) {
+	config := &Config{OidcProviderConfig: staticProviderConfig("corporate")}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.LoginStateEncoder = func(c *fiber.Ctx) (string, error) {
+		return `{"cart":42}`, nil
+	}
+	config.LoginSuccessHandler = func(state string, c *fiber.Ctx) error {
+		return c.SendString(state)
+	}
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := fiberOidc.(*FiberOidcStruct)
+	app := fiber.New()
+	app.Get(fiberOidc.CallbackPath(), fiberOidc.CallbackHandler())
+	app.Get("/api/cart", fiberOidc.ProtectedRoute(), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	req := httptest.NewRequest(fiber.MethodGet, "/api/cart", nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]string{}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	// custom app state isn't filtered like a return_to
+	if appState := startProgrammaticLogin(t, obj, app, body["login_url"]); appState != `{"cart":42}` {
+		t.Fatalf("Unexpected app state: %v", appState)
+	}
+
+	loginUrl, err := url.Parse(body["login_url"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, appState := range []string{"/admin", loginUrl.Query().Get("app_state")[1:]} {
+		resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, loginUrl.Path+"?"+url.Values{"app_state": {appState}}.Encode(), nil))
+		if err != nil || resp.StatusCode != fiber.StatusBadRequest {
+			t.Fatalf("Expected a forged app state to be rejected: %v %v", resp.StatusCode, err)
+		}
+	}
+}
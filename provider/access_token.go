@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"golang.org/x/oauth2"
+)
+
+// allowed clock skew, for exp, nbf and iat
+const accessTokenLeeway = time.Minute
+
+// AccessTokenVerifier verifies JWT access tokens for this API (as a resource server),
+// which are for a different audience than id tokens
+// see https://www.rfc-editor.org/rfc/rfc9068
+type AccessTokenVerifier struct {
+	issuer      string
+	keySet      gooidc.KeySet
+	audiences   []string
+	clientIds   []string
+	signingAlgs []jose.SignatureAlgorithm
+	requireType bool
+}
+
+// AccessToken is a verified JWT access token
+type AccessToken struct {
+	Issuer   string
+	Subject  string
+	ClientId string
+	Audience []string
+	Scopes   []string
+	Expiry   time.Time
+	IssuedAt time.Time
+
+	claims json.RawMessage
+}
+
+func (obj *AccessToken) Claims(v interface{}) error {
+	return json.Unmarshal(obj.claims, v)
+}
+
+// a claim that may be a (space separated) string, or an array of strings (eg: aud, scope, scp)
+type stringOrArray []string
+
+func (obj *stringOrArray) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*obj = strings.Fields(value)
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*obj = values
+	return nil
+}
+
+// a NumericDate claim, which may be fractional (as go-oidc accepts)
+type numericDate time.Time
+
+func (obj *numericDate) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	unix, err := number.Int64()
+	if err != nil {
+		fractional, err := number.Float64()
+		if err != nil {
+			return err
+		}
+		unix = int64(fractional)
+	}
+	*obj = numericDate(time.Unix(unix, 0))
+	return nil
+}
+
+type accessTokenClaims struct {
+	Issuer    string        `json:"iss"`
+	Subject   string        `json:"sub"`
+	Audience  stringOrArray `json:"aud"`
+	ClientId  string        `json:"client_id"`
+	Scope     stringOrArray `json:"scope"`
+	Scp       stringOrArray `json:"scp"`
+	Expiry    *numericDate  `json:"exp"`
+	IssuedAt  *numericDate  `json:"iat"`
+	NotBefore *numericDate  `json:"nbf"`
+}
+
+// newAccessTokenVerifier returns nil if there are no AccessTokenAudiences
+func newAccessTokenVerifier(issuer string, keySet gooidc.KeySet, config OidcProviderConfig) *AccessTokenVerifier {
+	if len(config.AccessTokenAudiences) == 0 {
+		return nil
+	}
+	algs := config.AccessTokenSigningAlgs
+	if len(algs) == 0 {
+		algs = config.SupportedSigningAlgs
+	}
+	if len(algs) == 0 {
+		algs = []string{gooidc.RS256}
+	}
+	signingAlgs := make([]jose.SignatureAlgorithm, 0, len(algs))
+	for _, alg := range algs {
+		signingAlgs = append(signingAlgs, jose.SignatureAlgorithm(alg))
+	}
+	return &AccessTokenVerifier{
+		issuer:      issuer,
+		keySet:      keySet,
+		audiences:   config.AccessTokenAudiences,
+		clientIds:   config.AccessTokenClientIds,
+		signingAlgs: signingAlgs,
+		requireType: config.RequireAccessTokenType,
+	}
+}
+
+func (obj *AccessTokenVerifier) Verify(ctx context.Context, rawAccessToken string) (*AccessToken, error) {
+	jws, err := jose.ParseSigned(rawAccessToken, obj.signingAlgs)
+	if err != nil {
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	if obj.requireType {
+		typ, _ := jws.Signatures[0].Protected.ExtraHeaders[jose.HeaderType].(string)
+		if !strings.EqualFold(typ, "at+jwt") && !strings.EqualFold(typ, "application/at+jwt") {
+			return nil, EnsureErr(fmt.Errorf("unexpected token type: %q", typ), ErrNotAuthorized)
+		}
+	}
+	payload, err := obj.keySet.VerifySignature(ctx, rawAccessToken)
+	if err != nil {
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	claims := &accessTokenClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != obj.issuer:
+		return nil, EnsureErr(fmt.Errorf("unexpected issuer: %v", claims.Issuer), ErrNotAuthorized)
+	case !slices.ContainsFunc(claims.Audience, func(audience string) bool { return slices.Contains(obj.audiences, audience) }):
+		return nil, ErrAudienceMismatch
+	case claims.Subject == "":
+		return nil, EnsureErr(errors.New("access token has no sub"), ErrNotAuthorized)
+	case claims.Expiry == nil:
+		return nil, EnsureErr(errors.New("access token has no exp"), ErrNotAuthorized)
+	case claims.IssuedAt == nil:
+		return nil, EnsureErr(errors.New("access token has no iat"), ErrNotAuthorized)
+	case now.Add(-accessTokenLeeway).After(time.Time(*claims.Expiry)):
+		return nil, EnsureErr(errors.New("access token is expired"), ErrTokenExpired)
+	case claims.NotBefore != nil && now.Add(accessTokenLeeway).Before(time.Time(*claims.NotBefore)):
+		return nil, EnsureErr(errors.New("access token is not valid yet"), ErrNotAuthorized)
+	case len(obj.clientIds) != 0 && !slices.Contains(obj.clientIds, claims.ClientId):
+		return nil, EnsureErr(fmt.Errorf("unexpected client_id: %v", claims.ClientId), ErrNotAuthorized)
+	}
+
+	scopes := claims.Scope
+	if len(scopes) == 0 {
+		scopes = claims.Scp
+	}
+	return &AccessToken{
+		Issuer:   claims.Issuer,
+		Subject:  claims.Subject,
+		ClientId: claims.ClientId,
+		Audience: claims.Audience,
+		Scopes:   scopes,
+		Expiry:   time.Time(*claims.Expiry),
+		IssuedAt: time.Time(*claims.IssuedAt),
+		claims:   payload,
+	}, nil
+}
+
+func (obj *OidcProviders) AccessTokenVerifier(ctx context.Context) (*AccessTokenVerifier, error) {
+	state, err := obj.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if state.accessTokenVerifier == nil {
+		return nil, ErrNoAccessTokenVerifier
+	}
+	return state.accessTokenVerifier, nil
+}
+
+// ValidateAccessToken verifies a JWT access token (see AccessTokenVerifier)
+// N.B. this does NOT bind ProviderAuth to a context on success
+func (obj *OidcProviders) ValidateAccessToken(ctx context.Context, rawAccessToken string) (*ProviderAuth, error) {
+	if rawAccessToken == "" {
+		return nil, ErrNoAuth
+	}
+	verifier, err := obj.AccessTokenVerifier(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	accessToken, err := verifier.Verify(ctx, rawAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderAuth{
+		Valid:       true,
+		AccessToken: rawAccessToken,
+		Subject:     accessToken.Subject,
+		Scopes:      accessToken.Scopes,
+		Expiry:      accessToken.Expiry,
+		rawClaims:   accessToken.claims,
+		oauth2Token: &oauth2.Token{
+			AccessToken: rawAccessToken,
+			TokenType:   "Bearer",
+			Expiry:      accessToken.Expiry,
+		},
+	}, nil
+}
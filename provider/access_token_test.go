@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+)
+
+const testAudience = "https://api.example.com"
+
+func (obj *testSigner) signAccessToken(t *testing.T, typ string, claims map[string]any) string {
+	options := &jose.SignerOptions{}
+	if typ != "" {
+		options = options.WithType(jose.ContentType(typ))
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: obj.key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestAccessTokenVerifier(t *testing.T) {
+	signer := newTestSigner(t)
+	keySet := &gooidc.StaticKeySet{PublicKeys: []crypto.PublicKey{signer.key.Public()}}
+	verifier := newAccessTokenVerifier(testIssuer, keySet, OidcProviderConfig{
+		AccessTokenAudiences:   []string{testAudience},
+		AccessTokenClientIds:   []string{testClientId},
+		RequireAccessTokenType: true,
+	})
+	accessTokenClaims := func() map[string]any {
+		return map[string]any{
+			"iss":       testIssuer,
+			"sub":       "test-subject",
+			"aud":       []string{"other", testAudience},
+			"client_id": testClientId,
+			"scp":       []string{"read", "write"},
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"iat":       time.Now().Unix(),
+		}
+	}
+
+	accessToken, err := verifier.Verify(context.Background(), signer.signAccessToken(t, "at+jwt", accessTokenClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken.Subject != "test-subject" || len(accessToken.Scopes) != 2 || accessToken.Scopes[0] != "read" {
+		t.Fatalf("Unexpected access token: %+v", accessToken)
+	}
+
+	claims := accessTokenClaims()
+	delete(claims, "scp")
+	claims["scope"] = "admin"
+	accessToken, err = verifier.Verify(context.Background(), signer.signAccessToken(t, "application/at+jwt", claims))
+	if err != nil || len(accessToken.Scopes) != 1 || accessToken.Scopes[0] != "admin" {
+		t.Fatalf("Unexpected access token: %+v %v", accessToken, err)
+	}
+
+	// scope as an array, and fractional NumericDates
+	claims = accessTokenClaims()
+	delete(claims, "scp")
+	claims["scope"] = []string{"read", "write"}
+	claims["exp"] = float64(time.Now().Add(time.Hour).Unix()) + 0.5
+	claims["iat"] = float64(time.Now().Unix()) + 0.25
+	accessToken, err = verifier.Verify(context.Background(), signer.signAccessToken(t, "at+jwt", claims))
+	if err != nil || len(accessToken.Scopes) != 2 || accessToken.Scopes[1] != "write" || time.Until(accessToken.Expiry) < 50*time.Minute {
+		t.Fatalf("Unexpected access token: %+v %v", accessToken, err)
+	}
+
+	// an id token, for the client rather than the api
+	_, err = verifier.Verify(context.Background(), signer.signAccessToken(t, "", signer.idTokenClaims("")))
+	if !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		modify func(claims map[string]any)
+		err    error
+	}{
+		"audience":  {func(claims map[string]any) { claims["aud"] = testClientId }, ErrAudienceMismatch},
+		"expired":   {func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, ErrTokenExpired},
+		"issuer":    {func(claims map[string]any) { claims["iss"] = "https://other.example.com" }, ErrNotAuthorized},
+		"client id": {func(claims map[string]any) { claims["client_id"] = "other-client" }, ErrNotAuthorized},
+		"no sub":    {func(claims map[string]any) { delete(claims, "sub") }, ErrNotAuthorized},
+		"no iat":    {func(claims map[string]any) { delete(claims, "iat") }, ErrNotAuthorized},
+		"no exp":    {func(claims map[string]any) { delete(claims, "exp") }, ErrNotAuthorized},
+	} {
+		claims := accessTokenClaims()
+		test.modify(claims)
+		_, err = verifier.Verify(context.Background(), signer.signAccessToken(t, "at+jwt", claims))
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%v: Unexpected error: %v", name, err)
+		}
+	}
+}
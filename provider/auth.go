@@ -2,6 +2,10 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
 
 	gooidc "github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
@@ -9,10 +13,69 @@ import (
 
 type providerAuthContextKey struct{}
 type ProviderAuth struct {
-	Valid       bool
-	RawToken    string
+	Valid bool
+
+	// the raw (encoded) ID token, which has been verified.
+	// blank if the auth is from an access token (eg: introspection)
+	RawIdToken string
+
+	// the access token, which is opaque to this middleware
+	AccessToken string
+
+	// the refresh token, if there is one
+	RefreshToken string
+
+	// the authenticated subject
+	Subject string
+
+	// the granted scopes, if known
+	Scopes []string
+
+	// when the token expires, if known.
+	// After a refresh without a new id token, this is when the access token
+	// expires, and the (expired) id token is still accepted until then
+	Expiry time.Time
+
 	oauth2Token *oauth2.Token
 	idToken     *gooidc.IDToken
+
+	// the claims, when there is no id token
+	rawClaims json.RawMessage
+}
+
+// an auth from a verified id token
+func idTokenAuth(rawIdToken string, idToken *gooidc.IDToken, oauth2Token *oauth2.Token) *ProviderAuth {
+	scope, _ := oauth2Token.Extra("scope").(string)
+	return &ProviderAuth{
+		Valid:        true,
+		RawIdToken:   rawIdToken,
+		AccessToken:  oauth2Token.AccessToken,
+		RefreshToken: oauth2Token.RefreshToken,
+		Subject:      idToken.Subject,
+		Scopes:       strings.Fields(scope),
+		Expiry:       idToken.Expiry,
+		idToken:      idToken,
+		oauth2Token:  oauth2Token,
+	}
+}
+
+// an auth from a refresh without a new id token, which keeps the (verified)
+// original identity until the access token expires
+func refreshedAuth(rawIdToken string, originalIdToken *gooidc.IDToken, oauth2Token *oauth2.Token) *ProviderAuth {
+	auth := idTokenAuth(rawIdToken, originalIdToken, oauth2Token)
+	if !oauth2Token.Expiry.IsZero() {
+		auth.Expiry = oauth2Token.Expiry
+	}
+	return auth
+}
+
+// a refresh response without a scope keeps the originally granted scope
+// see https://www.rfc-editor.org/rfc/rfc6749#section-5.1
+func withRefreshedScopes(auth *ProviderAuth, scope string) *ProviderAuth {
+	if len(auth.Scopes) == 0 {
+		auth.Scopes = strings.Fields(scope)
+	}
+	return auth
 }
 
 func BindAuth(ctx context.Context, auth *ProviderAuth) context.Context {
@@ -31,10 +94,13 @@ func GetAuth(ctx context.Context) *ProviderAuth {
 
 func (p *ProviderAuth) GetOauth2Token() *oauth2.Token {
 	if p.oauth2Token == nil && p.idToken != nil {
-		p.oauth2Token = &oauth2.Token{
-			AccessToken: p.RawToken,
-			Expiry:      p.idToken.Expiry,
-		}
+		p.oauth2Token = (&oauth2.Token{
+			AccessToken:  p.AccessToken,
+			RefreshToken: p.RefreshToken,
+			Expiry:       p.idToken.Expiry,
+		}).WithExtra(map[string]interface{}{
+			"id_token": p.RawIdToken,
+		})
 	}
 	return p.oauth2Token
 }
@@ -42,3 +108,15 @@ func (p *ProviderAuth) GetOauth2Token() *oauth2.Token {
 func (p *ProviderAuth) GetIdToken() *gooidc.IDToken {
 	return p.idToken
 }
+
+// Claims unmarshals the claims of the id token, or of the access token
+// (or introspection response) when there is no id token
+func (p *ProviderAuth) Claims(v interface{}) error {
+	if p.idToken != nil {
+		return p.idToken.Claims(v)
+	}
+	if p.rawClaims == nil {
+		return errors.New("no claims")
+	}
+	return json.Unmarshal(p.rawClaims, v)
+}
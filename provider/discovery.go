@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ProviderMetadata is the (relevant parts of the) discovery document
+// see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type ProviderMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	RevocationEndpoint    string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint"`
+	JwksUri               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+func metadataFromProvider(goOidcProvider *gooidc.Provider) (*ProviderMetadata, error) {
+	metadata := &ProviderMetadata{}
+	err := goOidcProvider.Claims(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (obj *OidcProviders) Metadata(ctx context.Context) (*ProviderMetadata, error) {
+	state, err := obj.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return state.metadata, nil
+}
+
+// Refresh re-runs discovery, and swaps in the new provider components.
+// On failure the existing components are kept
+func (obj *OidcProviders) Refresh(ctx context.Context) error {
+	obj.initMutex.Lock()
+	defer obj.initMutex.Unlock()
+
+	state, err := obj.newState(ctx)
+	if err != nil {
+		return errInitialization(err)
+	}
+	obj.initErr = nil
+	previous := obj.state.Swap(state)
+	obj.endpointFailures.Store(0)
+
+	if previous != nil && obj.OidcProviderConfig.OnMetadataChanged != nil && !reflect.DeepEqual(previous.metadata, state.metadata) {
+		obj.OidcProviderConfig.OnMetadataChanged(*previous.metadata, *state.metadata)
+	}
+	return nil
+}
+
+// RefreshPeriodically calls Refresh every DiscoveryRefreshInterval,
+// until the context is done.
+// Does nothing if there is no DiscoveryRefreshInterval
+func (obj *OidcProviders) RefreshPeriodically(ctx context.Context) {
+	interval := obj.OidcProviderConfig.DiscoveryRefreshInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// failures keep the existing provider, and will be retried
+			_ = obj.Refresh(ctx)
+		}
+	}
+}
+
+// isEndpointFailure is true when the endpoint itself failed (network errors,
+// 404s and 5xx), rather than the request to it being rejected
+func isEndpointFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	retrieveError := &oauth2.RetrieveError{}
+	if errors.As(err, &retrieveError) {
+		if retrieveError.Response == nil {
+			return true
+		}
+		status := retrieveError.Response.StatusCode
+		return status == http.StatusNotFound || status >= http.StatusInternalServerError
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// reportEndpointResult counts consecutive endpoint failures, and triggers a
+// background Refresh once there have been DiscoveryRefreshAfterFailures of them
+func (obj *OidcProviders) reportEndpointResult(err error) {
+	threshold := obj.OidcProviderConfig.DiscoveryRefreshAfterFailures
+	if threshold <= 0 {
+		return
+	}
+	if !isEndpointFailure(err) {
+		obj.endpointFailures.Store(0)
+		return
+	}
+	if obj.endpointFailures.Add(1) == int32(threshold) {
+		go func() {
+			// a failed refresh allows the count to trigger again
+			if obj.Refresh(context.Background()) != nil {
+				obj.endpointFailures.Store(0)
+			}
+		}()
+	}
+}
@@ -1,11 +1,84 @@
 package provider
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrNoAuth = errors.New("no auth supplied")
 var ErrInitialization = errors.New("error in initialization")
 var ErrNotAuthorized = errors.New("not authorized")
 var ErrTokenExpired = errors.New("token is expired")
+var ErrNoIdToken = errors.New("no id token in token response")
+var ErrNonceMismatch = errors.New("id token nonce mismatch")
+var ErrTokenIdentityMismatch = errors.New("refreshed id token has a different sub or iss")
+var ErrCookieDecode = errors.New("unable to decode cookie value")
+
+// login state (redirect/callback round trip) errors
+var ErrInvalidLoginState = errors.New("invalid login state")
+var ErrLoginStateMissing = EnsureErr(errors.New("login state missing"), ErrInvalidLoginState)
+var ErrLoginStateExpired = EnsureErr(errors.New("login state expired"), ErrInvalidLoginState)
+var ErrLoginStateMismatch = EnsureErr(errors.New("login state mismatch"), ErrInvalidLoginState)
+
+// the logout state (end session redirect/post logout callback round trip)
+var ErrInvalidLogoutState = errors.New("invalid logout state")
+
+// requests to the IdP's (token, revocation, introspection) endpoints
+var ErrEndpoint = errors.New("endpoint request failed")
+var ErrNoRevocationEndpoint = errors.New("provider has no revocation endpoint")
+
+// token introspection (RFC 7662)
+var ErrNoIntrospectionEndpoint = errors.New("provider has no introspection endpoint")
+var ErrTokenInactive = EnsureErr(errors.New("token is not active"), ErrNotAuthorized)
+
+// JWT access tokens (RFC 9068)
+var ErrNoAccessTokenVerifier = errors.New("no access token audiences are configured")
+var ErrAudienceMismatch = EnsureErr(errors.New("token is for a different audience"), ErrNotAuthorized)
+
+// provider registry lookups
+var ErrUnknownProvider = errors.New("unknown provider")
+var ErrUnroutableToken = errors.New("token can't be routed to a provider, use Get(name)")
+
+// back-channel logout tokens
+var ErrInvalidLogoutToken = errors.New("invalid logout token")
+
+// error codes the IdP may return on the callback
+// see https://www.rfc-editor.org/rfc/rfc6749#section-4.1.2.1
+// and https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+const (
+	CallbackErrorInvalidRequest           = "invalid_request"
+	CallbackErrorUnauthorizedClient       = "unauthorized_client"
+	CallbackErrorAccessDenied             = "access_denied"
+	CallbackErrorUnsupportedResponseType  = "unsupported_response_type"
+	CallbackErrorInvalidScope             = "invalid_scope"
+	CallbackErrorServerError              = "server_error"
+	CallbackErrorTemporarilyUnavailable   = "temporarily_unavailable"
+	CallbackErrorInteractionRequired      = "interaction_required"
+	CallbackErrorLoginRequired            = "login_required"
+	CallbackErrorAccountSelectionRequired = "account_selection_required"
+	CallbackErrorConsentRequired          = "consent_required"
+)
+
+var ErrCallback = errors.New("login failed")
+
+// CallbackError is an error response from the IdP on the callback
+// (eg: the user cancelled consent)
+type CallbackError struct {
+	Code        string
+	Description string
+	URI         string
+}
+
+func (e *CallbackError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%v: %v: %v", ErrCallback, e.Code, e.Description)
+	}
+	return fmt.Sprintf("%v: %v", ErrCallback, e.Code)
+}
+
+func (e *CallbackError) Is(target error) bool {
+	return target == ErrCallback
+}
 
 func errInitialization(err error) error {
 	return EnsureErr(err, ErrInitialization)
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// how long a (positive) introspection result is cached for, if not configured
+const DefaultIntrospectionCacheMaxTTL = time.Minute
+
+// cached entries are only pruned once there are this many
+const introspectionCachePruneSize = 1024
+
+// the relevant parts of an introspection response
+// see https://www.rfc-editor.org/rfc/rfc7662#section-2.2
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope"`
+	ClientId string `json:"client_id"`
+	Subject  string `json:"sub"`
+	Expiry   int64  `json:"exp"`
+}
+
+type introspectionCacheEntry struct {
+	auth    *ProviderAuth
+	expires time.Time
+}
+
+// introspectionCache holds active tokens, keyed by a hash of the token
+type introspectionCache struct {
+	mutex   sync.Mutex
+	entries map[[sha256.Size]byte]introspectionCacheEntry
+}
+
+func (obj *introspectionCache) get(key [sha256.Size]byte) *ProviderAuth {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	entry, ok := obj.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(obj.entries, key)
+		return nil
+	}
+	return entry.auth
+}
+
+func (obj *introspectionCache) set(key [sha256.Size]byte, auth *ProviderAuth, expires time.Time) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if obj.entries == nil {
+		obj.entries = make(map[[sha256.Size]byte]introspectionCacheEntry)
+	}
+	if len(obj.entries) >= introspectionCachePruneSize {
+		now := time.Now()
+		for existingKey, entry := range obj.entries {
+			if now.After(entry.expires) {
+				delete(obj.entries, existingKey)
+			}
+		}
+	}
+	obj.entries[key] = introspectionCacheEntry{auth: auth, expires: expires}
+}
+
+// IntrospectToken validates an (opaque) access token at the introspection endpoint.
+// Active tokens are cached until they expire, for at most the IntrospectionCacheMaxTTL
+// see https://www.rfc-editor.org/rfc/rfc7662
+func (obj *OidcProviders) IntrospectToken(ctx context.Context, accessToken string) (*ProviderAuth, error) {
+	if accessToken == "" {
+		return nil, ErrNoAuth
+	}
+	maxTTL := obj.OidcProviderConfig.IntrospectionCacheMaxTTL
+	if maxTTL == 0 {
+		maxTTL = DefaultIntrospectionCacheMaxTTL
+	}
+	key := sha256.Sum256([]byte(accessToken))
+	if maxTTL > 0 {
+		if auth := obj.introspectionCache.get(key); auth != nil {
+			return auth, nil
+		}
+	}
+
+	metadata, err := obj.Metadata(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	if metadata.IntrospectionEndpoint == "" {
+		return nil, ErrNoIntrospectionEndpoint
+	}
+	form := url.Values{}
+	form.Set("token", accessToken)
+	form.Set("token_type_hint", TokenTypeHintAccessToken)
+	body, err := obj.postForm(ctx, metadata.IntrospectionEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	response := &introspectionResponse{}
+	if err = json.Unmarshal(body, response); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+	if !response.Active {
+		return nil, ErrTokenInactive
+	}
+
+	auth := &ProviderAuth{
+		Valid:       true,
+		AccessToken: accessToken,
+		Subject:     response.Subject,
+		Scopes:      strings.Fields(response.Scope),
+		rawClaims:   body,
+	}
+	expires := time.Now().Add(maxTTL)
+	if response.Expiry != 0 {
+		auth.Expiry = time.Unix(response.Expiry, 0)
+		if time.Now().After(auth.Expiry) {
+			return nil, EnsureErr(errors.New("introspected token is expired"), ErrTokenExpired)
+		}
+		if auth.Expiry.Before(expires) {
+			expires = auth.Expiry
+		}
+	}
+	auth.oauth2Token = &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		Expiry:      auth.Expiry,
+	}
+	if maxTTL > 0 {
+		obj.introspectionCache.set(key, auth, expires)
+	}
+	return auth, nil
+}
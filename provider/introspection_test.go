@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	calls := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if _, _, ok := r.BasicAuth(); !ok || r.ParseForm() != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		response := map[string]any{"active": false}
+		if r.PostForm.Get("token") == "active-token" {
+			response = map[string]any{
+				"active": true,
+				"sub":    "test-subject",
+				"scope":  "read write",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+				"roles":  []string{"admin"},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:       testIssuer,
+			ClientId:     testClientId,
+			ClientSecret: "secret",
+			StaticMetadata: &StaticMetadata{
+				ProviderMetadata: ProviderMetadata{
+					JwksUri:               server.URL + "/jwks",
+					IntrospectionEndpoint: server.URL + "/introspect",
+				},
+			},
+		},
+	}
+
+	auth, err := providers.IntrospectToken(context.Background(), "active-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.Valid || auth.Subject != "test-subject" || len(auth.Scopes) != 2 || auth.Scopes[1] != "write" || auth.Expiry.IsZero() {
+		t.Fatalf("Unexpected auth: %+v", auth)
+	}
+	claims := struct {
+		Roles []string `json:"roles"`
+	}{}
+	if err = auth.Claims(&claims); err != nil || len(claims.Roles) != 1 {
+		t.Fatalf("Unexpected claims: %v %v", claims, err)
+	}
+
+	// cached
+	if _, err = providers.IntrospectToken(context.Background(), "active-token"); err != nil || calls.Load() != 1 {
+		t.Fatalf("Expected a cached result: %v %v", calls.Load(), err)
+	}
+
+	_, err = providers.IntrospectToken(context.Background(), "inactive-token")
+	if !errors.Is(err, ErrTokenInactive) || !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// negative results are not cached
+	_, _ = providers.IntrospectToken(context.Background(), "inactive-token")
+	if calls.Load() != 3 {
+		t.Fatalf("Unexpected calls: %v", calls.Load())
+	}
+}
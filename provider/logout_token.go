@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// the event that identifies a logout token
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// how old (by iat) a logout token can be, and how far in the future
+const logoutTokenMaxAge = 5 * time.Minute
+const logoutTokenClockSkew = time.Minute
+
+// LogoutToken is a verified back-channel logout token.
+// At least one of Subject and SessionId is set
+// see https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken
+type LogoutToken struct {
+	Issuer    string
+	Subject   string
+	SessionId string
+	Jti       string
+	IssuedAt  time.Time
+}
+
+type logoutTokenClaims struct {
+	SessionId string                     `json:"sid"`
+	Jti       string                     `json:"jti"`
+	Expiry    *int64                     `json:"exp"`
+	Events    map[string]json.RawMessage `json:"events"`
+}
+
+func (obj *OidcProviders) VerifyLogoutToken(ctx context.Context, rawLogoutToken string) (*LogoutToken, error) {
+	if rawLogoutToken == "" {
+		return nil, ErrInvalidLogoutToken
+	}
+	// exp is optional in logout tokens, so it is checked below
+	verifier, err := obj.expiredIdTokenVerifier(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	token, err := verifier.Verify(ctx, rawLogoutToken)
+	if err != nil {
+		return nil, EnsureErr(err, ErrInvalidLogoutToken)
+	}
+	claims := &logoutTokenClaims{}
+	if err = token.Claims(claims); err != nil {
+		return nil, EnsureErr(err, ErrInvalidLogoutToken)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Expiry != nil && now.After(time.Unix(*claims.Expiry, 0)):
+		return nil, EnsureErr(errors.New("logout token is expired"), ErrInvalidLogoutToken)
+	case token.IssuedAt.IsZero() || now.Sub(token.IssuedAt) > logoutTokenMaxAge || token.IssuedAt.Sub(now) > logoutTokenClockSkew:
+		return nil, EnsureErr(errors.New("logout token iat is out of range"), ErrInvalidLogoutToken)
+	case !isJsonObject(claims.Events[BackChannelLogoutEvent]):
+		return nil, EnsureErr(errors.New("logout token has no back-channel logout event"), ErrInvalidLogoutToken)
+	case token.Nonce != "":
+		// so that an id token can't be used as a logout token
+		return nil, EnsureErr(errors.New("logout token must not have a nonce"), ErrInvalidLogoutToken)
+	case token.Subject == "" && claims.SessionId == "":
+		return nil, EnsureErr(errors.New("logout token has no sub or sid"), ErrInvalidLogoutToken)
+	case claims.Jti == "":
+		return nil, EnsureErr(errors.New("logout token has no jti"), ErrInvalidLogoutToken)
+	}
+
+	return &LogoutToken{
+		Issuer:    token.Issuer,
+		Subject:   token.Subject,
+		SessionId: claims.SessionId,
+		Jti:       claims.Jti,
+		IssuedAt:  token.IssuedAt,
+	}, nil
+}
+
+func isJsonObject(value json.RawMessage) bool {
+	object := map[string]json.RawMessage{}
+	return len(value) != 0 && json.Unmarshal(value, &object) == nil
+}
@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyLogoutToken(t *testing.T) {
+	signer := newTestSigner(t)
+	providers := signer.providers()
+	logoutClaims := func() map[string]any {
+		return map[string]any{
+			"iss":    testIssuer,
+			"aud":    testClientId,
+			"sub":    "test-subject",
+			"sid":    "test-session",
+			"jti":    "test-jti",
+			"iat":    time.Now().Unix(),
+			"events": map[string]any{BackChannelLogoutEvent: map[string]any{}},
+		}
+	}
+
+	logoutToken, err := providers.VerifyLogoutToken(context.Background(), signer.sign(t, logoutClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logoutToken.SessionId != "test-session" || logoutToken.Subject != "test-subject" || logoutToken.Jti != "test-jti" {
+		t.Fatalf("Unexpected logout token: %+v", logoutToken)
+	}
+
+	for name, modify := range map[string]func(claims map[string]any){
+		"nonce":     func(claims map[string]any) { claims["nonce"] = "nonce" },
+		"no events": func(claims map[string]any) { delete(claims, "events") },
+		"old":       func(claims map[string]any) { claims["iat"] = time.Now().Add(-time.Hour).Unix() },
+		"expired":   func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no jti":    func(claims map[string]any) { delete(claims, "jti") },
+		"no subject": func(claims map[string]any) {
+			delete(claims, "sub")
+			delete(claims, "sid")
+		},
+		"audience": func(claims map[string]any) { claims["aud"] = "other-client" },
+	} {
+		claims := logoutClaims()
+		modify(claims)
+		_, err = providers.VerifyLogoutToken(context.Background(), signer.sign(t, claims))
+		if !errors.Is(err, ErrInvalidLogoutToken) {
+			t.Fatalf("%v: Unexpected error: %v", name, err)
+		}
+	}
+}
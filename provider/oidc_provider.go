@@ -2,6 +2,13 @@ package provider
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	gooidc "github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
@@ -12,94 +19,264 @@ type Providers interface {
 	Initialize(ctx context.Context) error
 
 	// validate an inbound auth
+	// The jwt is an ID token, the access token (if any) is carried along
 	// N.B. this does NOT bind ProviderAuth to a context on success
-	ValidateJwt(ctx context.Context, jwt string, refreshToken string) (*ProviderAuth, error)
+	ValidateJwt(ctx context.Context, rawIdToken string, accessToken string, refreshToken string) (*ProviderAuth, error)
+
+	// validate a stored token set (eg: from cookies or a session).
+	// As ValidateJwt, but an expired id token is still accepted until the
+	// token set Expiry (with a refresh token), see ProviderAuth.Expiry.
+	// The granted scopes are carried along from the "scope" extra (see oauth2.Token.WithExtra)
+	ValidateTokens(ctx context.Context, rawIdToken string, tokens *oauth2.Token) (*ProviderAuth, error)
+
+	// validate the token response from a code exchange
+	// the id_token is verified (including the nonce), and the access token
+	// and refresh token are carried along as-is
+	ValidateTokenResponse(ctx context.Context, oauth2Token *oauth2.Token, nonce string) (*ProviderAuth, error)
+
+	// verify an id token from a token response, including the nonce
+	// that was sent on the auth request
+	VerifyIdToken(ctx context.Context, rawIdToken string, nonce string) (*gooidc.IDToken, error)
+
+	// verify a back-channel logout token
+	VerifyLogoutToken(ctx context.Context, rawLogoutToken string) (*LogoutToken, error)
+
+	// exchange an authorization code for a token response
+	Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+
+	// validate a JWT access token (for this API, see AccessTokenAudiences)
+	ValidateAccessToken(ctx context.Context, rawAccessToken string) (*ProviderAuth, error)
+
+	// validate an (opaque) access token at the introspection endpoint
+	IntrospectToken(ctx context.Context, accessToken string) (*ProviderAuth, error)
+
+	// revoke a token at the revocation endpoint, with an optional token type hint
+	// see TokenTypeHintRefreshToken and TokenTypeHintAccessToken
+	RevokeToken(ctx context.Context, token string, hint string) error
+
+	// re-run discovery, and swap in the new provider components
+	Refresh(ctx context.Context) error
 
 	// individual provider components
+	Metadata(ctx context.Context) (*ProviderMetadata, error)
 	GoOidcProvider(ctx context.Context) (*gooidc.Provider, error)
 	Oauth2Config(ctx context.Context) (*oauth2.Config, error)
 	IdTokenVerifier(ctx context.Context) (*gooidc.IDTokenVerifier, error)
+	AccessTokenVerifier(ctx context.Context) (*AccessTokenVerifier, error)
 }
 
+// how long a failed initialization is remembered for, if not configured
+const DefaultInitRetryBackoff = 10 * time.Second
+
+// OidcProviders is safe for concurrent use.
+// Initialization (discovery) is lazy, only runs one at a time, and a
+// failure is remembered for the InitRetryBackoff
 type OidcProviders struct {
 	OidcProviderConfig OidcProviderConfig
-	oauth2Config       *oauth2.Config
-	goOidcProvider     *gooidc.Provider
-	idTokenVerifier    *gooidc.IDTokenVerifier
+
+	state atomic.Pointer[providerState]
+
+	// guards initialization (and refresh)
+	initMutex   sync.Mutex
+	initErr     error
+	initErrTime time.Time
+
+	// consecutive endpoint failures, since the last success
+	endpointFailures atomic.Int32
+
+	introspectionCache introspectionCache
+}
+
+// everything built from discovery
+type providerState struct {
+	metadata               *ProviderMetadata
+	goOidcProvider         *gooidc.Provider
+	oauth2Config           *oauth2.Config
+	idTokenVerifier        *gooidc.IDTokenVerifier
+	expiredIdTokenVerifier *gooidc.IDTokenVerifier
+	accessTokenVerifier    *AccessTokenVerifier
 }
 
 func (obj *OidcProviders) Initialize(ctx context.Context) error {
-	_, err := obj.GoOidcProvider(ctx)
+	_, err := obj.getState(ctx)
+	return err
+}
+
+func (obj *OidcProviders) getState(ctx context.Context) (*providerState, error) {
+	if state := obj.state.Load(); state != nil {
+		return state, nil
+	}
+
+	obj.initMutex.Lock()
+	defer obj.initMutex.Unlock()
+	// may have been initialized while waiting
+	if state := obj.state.Load(); state != nil {
+		return state, nil
+	}
+
+	backoff := obj.OidcProviderConfig.InitRetryBackoff
+	if backoff == 0 {
+		backoff = DefaultInitRetryBackoff
+	}
+	if obj.initErr != nil && time.Since(obj.initErrTime) < backoff {
+		return nil, obj.initErr
+	}
+
+	state, err := obj.newState(ctx)
 	if err != nil {
-		return err
+		obj.initErr = errInitialization(err)
+		obj.initErrTime = time.Now()
+		return nil, obj.initErr
 	}
-	_, err = obj.Oauth2Config(ctx)
+	obj.initErr = nil
+	obj.state.Store(state)
+	return state, nil
+}
+
+func (obj *OidcProviders) newState(ctx context.Context) (*providerState, error) {
+	if obj.OidcProviderConfig.StaticMetadata != nil {
+		return obj.newStaticState(ctx)
+	}
+
+	goOidcProvider, err := gooidc.NewProvider(ctx, obj.OidcProviderConfig.Issuer)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = obj.IdTokenVerifier(ctx)
+	metadata, err := metadataFromProvider(goOidcProvider)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	keySet := remoteKeySet(ctx, metadata.JwksUri)
+	return obj.buildState(metadata, goOidcProvider, keySet), nil
 }
 
-func (obj *OidcProviders) GoOidcProvider(ctx context.Context) (*gooidc.Provider, error) {
-	if obj.goOidcProvider == nil {
-		config := obj.OidcProviderConfig
-		oidcProvider, err := gooidc.NewProvider(ctx, config.Issuer)
-		if err != nil {
-			return nil, errInitialization(err)
-		}
-		obj.goOidcProvider = oidcProvider
+// a key set that fetches with the http client of the (initialization) context,
+// see gooidc.ClientContext, but outlives its deadline
+func remoteKeySet(ctx context.Context, jwksUri string) gooidc.KeySet {
+	keySetCtx := context.Background()
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client != nil {
+		keySetCtx = gooidc.ClientContext(keySetCtx, client)
 	}
-	return obj.goOidcProvider, nil
+	return gooidc.NewRemoteKeySet(keySetCtx, jwksUri)
 }
 
-func (obj *OidcProviders) Oauth2Config(ctx context.Context) (*oauth2.Config, error) {
-	if obj.oauth2Config == nil {
-		goOidcProvider, err := obj.GoOidcProvider(ctx)
-		if err != nil {
-			return nil, errInitialization(err)
-		}
-		config := obj.OidcProviderConfig
-		obj.oauth2Config = &oauth2.Config{
+// the same provider components, however the metadata was obtained
+func (obj *OidcProviders) buildState(metadata *ProviderMetadata, goOidcProvider *gooidc.Provider, keySet gooidc.KeySet) *providerState {
+	config := obj.OidcProviderConfig
+	signingAlgs := config.SupportedSigningAlgs
+	if len(signingAlgs) == 0 {
+		signingAlgs = metadata.SigningAlgs
+	}
+
+	return &providerState{
+		metadata:       metadata,
+		goOidcProvider: goOidcProvider,
+		oauth2Config: &oauth2.Config{
 			ClientID:     config.ClientId,
 			ClientSecret: config.ClientSecret,
 			Endpoint:     goOidcProvider.Endpoint(),
 			RedirectURL:  config.RedirectUri,
 			Scopes:       config.Scopes,
-		}
+		},
+		idTokenVerifier: gooidc.NewVerifier(metadata.Issuer, keySet, &gooidc.Config{
+			ClientID:             config.ClientId,
+			SupportedSigningAlgs: signingAlgs,
+		}),
+		// verifies everything except expiry, for use when refreshing
+		expiredIdTokenVerifier: gooidc.NewVerifier(metadata.Issuer, keySet, &gooidc.Config{
+			ClientID:             config.ClientId,
+			SupportedSigningAlgs: signingAlgs,
+			SkipExpiryCheck:      true,
+		}),
+		accessTokenVerifier: newAccessTokenVerifier(metadata.Issuer, keySet, config),
+	}
+}
+
+func (obj *OidcProviders) GoOidcProvider(ctx context.Context) (*gooidc.Provider, error) {
+	state, err := obj.getState(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return obj.oauth2Config, nil
+	return state.goOidcProvider, nil
+}
+
+func (obj *OidcProviders) Oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	state, err := obj.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return state.oauth2Config, nil
 }
 
 func (obj *OidcProviders) IdTokenVerifier(ctx context.Context) (*gooidc.IDTokenVerifier, error) {
-	if obj.idTokenVerifier == nil {
-		goOidcProvider, err := obj.GoOidcProvider(ctx)
-		if err != nil {
-			return nil, errInitialization(err)
-		}
-		config := obj.OidcProviderConfig
+	state, err := obj.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return state.idTokenVerifier, nil
+}
 
-		// cache id token verifier
-		idTokenVerifier := goOidcProvider.Verifier(&gooidc.Config{
-			ClientID:             config.ClientId,
-			SupportedSigningAlgs: config.SupportedSigningAlgs,
-		})
-		obj.idTokenVerifier = idTokenVerifier
+func (obj *OidcProviders) expiredIdTokenVerifier(ctx context.Context) (*gooidc.IDTokenVerifier, error) {
+	state, err := obj.getState(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return obj.idTokenVerifier, nil
+	return state.expiredIdTokenVerifier, nil
 }
 
-func (obj *OidcProviders) ValidateJwt(ctx context.Context, jwt string, refreshToken string) (*ProviderAuth, error) {
-	if jwt == "" {
-		return nil, ErrNoAuth
+func (obj *OidcProviders) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	oauth2Config, err := obj.Oauth2Config(ctx)
+	if err != nil {
+		return nil, err
 	}
+	oauth2Token, err := oauth2Config.Exchange(ctx, code, opts...)
+	obj.reportEndpointResult(err)
+	return oauth2Token, err
+}
 
-	oauth2Token := &oauth2.Token{
-		AccessToken:  jwt,
+func (obj *OidcProviders) VerifyIdToken(ctx context.Context, rawIdToken string, nonce string) (*gooidc.IDToken, error) {
+	if rawIdToken == "" {
+		return nil, ErrNoIdToken
+	}
+	idTokenVerifier, err := obj.IdTokenVerifier(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	idToken, err := idTokenVerifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+	return idToken, nil
+}
+
+func (obj *OidcProviders) ValidateTokenResponse(ctx context.Context, oauth2Token *oauth2.Token, nonce string) (*ProviderAuth, error) {
+	rawIdToken, _ := oauth2Token.Extra("id_token").(string)
+	idToken, err := obj.VerifyIdToken(ctx, rawIdToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	auth := idTokenAuth(rawIdToken, idToken, oauth2Token)
+	if len(auth.Scopes) == 0 {
+		// an omitted scope is the requested scope
+		// see https://www.rfc-editor.org/rfc/rfc6749#section-5.1
+		auth.Scopes = obj.OidcProviderConfig.Scopes
+	}
+	return auth, nil
+}
+
+func (obj *OidcProviders) ValidateJwt(ctx context.Context, rawIdToken string, accessToken string, refreshToken string) (*ProviderAuth, error) {
+	return obj.ValidateTokens(ctx, rawIdToken, &oauth2.Token{
+		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+	})
+}
+
+func (obj *OidcProviders) ValidateTokens(ctx context.Context, rawIdToken string, tokens *oauth2.Token) (*ProviderAuth, error) {
+	if rawIdToken == "" {
+		return nil, ErrNoAuth
 	}
 
 	idTokenVerifier, err := obj.IdTokenVerifier(ctx)
@@ -107,68 +284,121 @@ func (obj *OidcProviders) ValidateJwt(ctx context.Context, jwt string, refreshTo
 		return nil, errInitialization(err)
 	}
 
-	// Parse the JWT
-	// token, err := jwt.Parse([]byte(tokenSring))
-	idToken, err := idTokenVerifier.Verify(ctx, jwt)
-	if err != nil {
-		if tokenExpiredError, ok := err.(*gooidc.TokenExpiredError); ok {
-
-			// auto-refresh doesn't seem to work with the oauth2Config thing
-			// need to set up some more detailed tests
-			if refreshToken != "" {
-				oauth2Token.Expiry = tokenExpiredError.Expiry
-				oauth2Config, err := obj.Oauth2Config(ctx)
-				if err != nil {
-					return nil, errInitialization(err)
-				}
-
-				oauth2Token, err = oauth2Config.TokenSource(ctx, oauth2Token).Token()
-				if err != nil {
-					return nil, err
-				}
-
-				// if the token changed, then we need the UPDATED refresh token (!!)
-				if oauth2Token != nil && oauth2Token.AccessToken != jwt {
-					jwt = oauth2Token.AccessToken
-
-					idToken, err = idTokenVerifier.Verify(ctx, jwt)
-					if err != nil {
-						if _, ok := err.(*gooidc.TokenExpiredError); ok {
-							err = EnsureErr(err, ErrTokenExpired)
-						}
-						return nil, err
-					}
-					// need to return this here
-					// golang is funny with nested scopes and variable shading
-					if err == nil && idToken != nil {
-						oauth2Token.Expiry = idToken.Expiry // ensure this is copied in correctly
-						return &ProviderAuth{
-							Valid:       true,
-							RawToken:    jwt,
-							idToken:     idToken,
-							oauth2Token: oauth2Token,
-						}, nil
-					}
-				}
-			} else {
-				err = EnsureErr(err, ErrTokenExpired)
-			}
-
-		} else {
-			return nil, EnsureErr(err, ErrNotAuthorized)
+	scope, _ := tokens.Extra("scope").(string)
+	idToken, err := idTokenVerifier.Verify(ctx, rawIdToken)
+	if err == nil {
+		return idTokenAuth(rawIdToken, idToken, (&oauth2.Token{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+			Expiry:       idToken.Expiry,
+		}).WithExtra(map[string]interface{}{
+			"scope": scope,
+		})), nil
+	}
+
+	if _, ok := err.(*gooidc.TokenExpiredError); !ok {
+		if obj.audienceMismatch(rawIdToken) {
+			return nil, EnsureErr(err, ErrAudienceMismatch)
+		}
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	if tokens.RefreshToken == "" {
+		return nil, EnsureErr(err, ErrTokenExpired)
+	}
+	if tokens.Expiry.After(time.Now()) {
+		// refreshed without a new id token, and still within the access token lifetime
+		originalIdToken, err := obj.verifyExpiredIdToken(ctx, rawIdToken)
+		if err != nil {
+			return nil, err
+		}
+		return refreshedAuth(rawIdToken, originalIdToken, tokens), nil
+	}
+	return obj.refresh(ctx, rawIdToken, tokens.RefreshToken, scope)
+}
+
+// whether an id token that failed verification is for a different audience
+// (eg: an access token for another API), so that it can be reported as such
+func (obj *OidcProviders) audienceMismatch(rawIdToken string) bool {
+	claims := struct {
+		Audience stringOrArray `json:"aud"`
+	}{}
+	if UnverifiedClaims(rawIdToken, &claims) != nil {
+		return false
+	}
+	return !slices.Contains(claims.Audience, obj.OidcProviderConfig.ClientId)
+}
+
+// verifies everything but the expiry of an id token
+func (obj *OidcProviders) verifyExpiredIdToken(ctx context.Context, rawIdToken string) (*gooidc.IDToken, error) {
+	// go-oidc checks expiry before signatures, so the expired token still
+	// needs to be verified before it can be trusted as the original identity
+	expiredIdTokenVerifier, err := obj.expiredIdTokenVerifier(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	idToken, err := expiredIdTokenVerifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	return idToken, nil
+}
+
+// refresh an expired id token
+// see https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse
+func (obj *OidcProviders) refresh(ctx context.Context, rawIdToken string, refreshToken string, scope string) (*ProviderAuth, error) {
+	originalIdToken, err := obj.verifyExpiredIdToken(ctx, rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+
+	oauth2Config, err := obj.Oauth2Config(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	// no access token forces the token source to refresh
+	oauth2Token, err := oauth2Config.TokenSource(ctx, &oauth2.Token{
+		RefreshToken: refreshToken,
+	}).Token()
+	obj.reportEndpointResult(err)
+	if err != nil {
+		retrieveErr := &oauth2.RetrieveError{}
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			// the refresh token is expired (or revoked), so a new login is required
+			return nil, EnsureErr(err, ErrTokenExpired)
 		}
+		// eg: the token endpoint is unavailable, the session may still be good
+		return nil, EnsureErr(err, ErrEndpoint)
 	}
 
-	if err == nil && idToken != nil {
-		oauth2Token.Expiry = idToken.Expiry // ensure this is copied in correctly
-		return &ProviderAuth{
-			Valid:       true,
-			RawToken:    jwt,
-			idToken:     idToken,
-			oauth2Token: oauth2Token,
-		}, nil
+	refreshedRawIdToken, _ := oauth2Token.Extra("id_token").(string)
+	if refreshedRawIdToken == "" {
+		// the IdP is not required to issue a new id token on refresh,
+		// in which case the (verified) original identity is retained
+		return withRefreshedScopes(refreshedAuth(rawIdToken, originalIdToken, oauth2Token), scope), nil
+	}
+
+	idTokenVerifier, err := obj.IdTokenVerifier(ctx)
+	if err != nil {
+		return nil, errInitialization(err)
+	}
+	idToken, err := idTokenVerifier.Verify(ctx, refreshedRawIdToken)
+	if err != nil {
+		if _, ok := err.(*gooidc.TokenExpiredError); ok {
+			return nil, EnsureErr(err, ErrTokenExpired)
+		}
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	if idToken.Issuer != originalIdToken.Issuer || idToken.Subject != originalIdToken.Subject {
+		return nil, ErrTokenIdentityMismatch
 	}
 
-	return nil, ErrNotAuthorized
+	return withRefreshedScopes(idTokenAuth(refreshedRawIdToken, idToken, oauth2Token), scope), nil
+}
 
+// the expected 'iss' claim, without initialization
+func (obj *OidcProviders) issuer() string {
+	if static := obj.OidcProviderConfig.StaticMetadata; static != nil && static.Issuer != "" {
+		return static.Issuer
+	}
+	return obj.OidcProviderConfig.Issuer
 }
@@ -1,5 +1,7 @@
 package provider
 
+import "time"
+
 type OidcProviderConfig struct {
 	// REQUIRED
 	Issuer string
@@ -20,4 +22,55 @@ type OidcProviderConfig struct {
 	// If set, limit the allowed signing args to this list
 	// defaults to RS256,RS512
 	SupportedSigningAlgs []string
+
+	// OPTIONAL
+	// The audiences (resource identifiers) of this API, accepted in JWT
+	// access tokens (see AccessTokenVerifier).
+	// Access tokens can only be verified if this is set
+	AccessTokenAudiences []string
+
+	// OPTIONAL
+	// If set, the access token client_id claim must be one of these
+	AccessTokenClientIds []string
+
+	// OPTIONAL
+	// If set, limit the access token signing algs to this list
+	// defaults to the SupportedSigningAlgs
+	AccessTokenSigningAlgs []string
+
+	// OPTIONAL
+	// If true, access tokens must have the 'typ: at+jwt' header (RFC 9068)
+	RequireAccessTokenType bool
+
+	// OPTIONAL, defaults to true
+	// Use PKCE (S256) on the authorization code flow.
+	// Only disable this for an IdP that rejects it.
+	UsePKCE *bool
+
+	// OPTIONAL, defaults to 1 minute
+	// The longest an (active) introspection result is cached for.
+	// Results are never cached past the token expiry. Negative disables caching
+	IntrospectionCacheMaxTTL time.Duration
+
+	// OPTIONAL
+	// If set, the provider is built from this instead of discovery
+	StaticMetadata *StaticMetadata
+
+	// OPTIONAL, defaults to 10 seconds
+	// How long a failed initialization (discovery) is remembered for,
+	// before it is retried
+	InitRetryBackoff time.Duration
+
+	// OPTIONAL
+	// If set, discovery is re-run on this interval (see RefreshPeriodically)
+	DiscoveryRefreshInterval time.Duration
+
+	// OPTIONAL
+	// If set, discovery is re-run after this many consecutive failures
+	// of the token endpoint
+	DiscoveryRefreshAfterFailures int
+
+	// OPTIONAL
+	// Called when a refresh finds that the discovery metadata has changed
+	OnMetadataChanged func(previous ProviderMetadata, current ProviderMetadata)
 }
@@ -1,3 +1,399 @@
 package provider
 
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"golang.org/x/oauth2"
+)
+
 var _ Providers = (*OidcProviders)(nil)
+
+const testIssuer = "https://issuer.example.com"
+const testClientId = "test-client"
+
+type testSigner struct {
+	key *rsa.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{key: key}
+}
+
+func (obj *testSigner) sign(t *testing.T, claims map[string]any) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: obj.key}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (obj *testSigner) idTokenClaims(nonce string) map[string]any {
+	return map[string]any{
+		"iss":   testIssuer,
+		"sub":   "test-subject",
+		"aud":   testClientId,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+}
+
+// providers with pre-built verifiers, so no discovery is required
+func (obj *testSigner) providers() *OidcProviders {
+	keySet := &gooidc.StaticKeySet{PublicKeys: []crypto.PublicKey{obj.key.Public()}}
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:   testIssuer,
+			ClientId: testClientId,
+		},
+	}
+	providers.state.Store(&providerState{
+		oauth2Config: &oauth2.Config{
+			ClientID: testClientId,
+		},
+		idTokenVerifier: gooidc.NewVerifier(testIssuer, keySet, &gooidc.Config{
+			ClientID: testClientId,
+		}),
+		expiredIdTokenVerifier: gooidc.NewVerifier(testIssuer, keySet, &gooidc.Config{
+			ClientID:        testClientId,
+			SkipExpiryCheck: true,
+		}),
+	})
+	return providers
+}
+
+// a token endpoint that responds to refreshes with the given id token
+func testTokenEndpoint(t *testing.T, rawIdToken string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+			t.Errorf("Unexpected token request: %v", r.PostForm)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "refreshed-access-token",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": "rotated-refresh-token",
+			"id_token":      rawIdToken,
+		})
+	}))
+}
+
+func TestVerifyIdTokenNonce(t *testing.T) {
+	ctx := context.Background()
+	signer := newTestSigner(t)
+	providers := signer.providers()
+	rawIdToken := signer.sign(t, signer.idTokenClaims("expected-nonce"))
+
+	idToken, err := providers.VerifyIdToken(ctx, rawIdToken, "expected-nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if idToken.Subject != "test-subject" {
+		t.Fatalf("Unexpected Subject: %v", idToken.Subject)
+	}
+
+	_, err = providers.VerifyIdToken(ctx, rawIdToken, "replayed-nonce")
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = providers.VerifyIdToken(ctx, "", "expected-nonce")
+	if !errors.Is(err, ErrNoIdToken) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateTokenResponse(t *testing.T) {
+	ctx := context.Background()
+	signer := newTestSigner(t)
+	providers := signer.providers()
+	rawIdToken := signer.sign(t, signer.idTokenClaims("nonce"))
+
+	oauth2Token := (&oauth2.Token{
+		AccessToken:  "opaque-access-token",
+		RefreshToken: "refresh-token",
+	}).WithExtra(map[string]interface{}{
+		"id_token": rawIdToken,
+	})
+
+	auth, err := providers.ValidateTokenResponse(ctx, oauth2Token, "nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.RawIdToken != rawIdToken || auth.AccessToken != "opaque-access-token" || auth.RefreshToken != "refresh-token" {
+		t.Fatalf("Unexpected tokens: %v", auth)
+	}
+
+	// an access token is never treated as an id token
+	_, err = providers.ValidateTokenResponse(ctx, &oauth2.Token{AccessToken: rawIdToken}, "nonce")
+	if !errors.Is(err, ErrNoIdToken) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateJwtRefresh(t *testing.T) {
+	ctx := context.Background()
+	signer := newTestSigner(t)
+
+	expiredClaims := signer.idTokenClaims("")
+	expiredClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+	expiredIdToken := signer.sign(t, expiredClaims)
+
+	refreshedIdToken := signer.sign(t, signer.idTokenClaims(""))
+	server := testTokenEndpoint(t, refreshedIdToken)
+	defer server.Close()
+	providers := signer.providers()
+	providers.state.Load().oauth2Config.Endpoint.TokenURL = server.URL
+
+	_, err := providers.ValidateJwt(ctx, expiredIdToken, "access-token", "")
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	auth, err := providers.ValidateJwt(ctx, expiredIdToken, "access-token", "refresh-token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.RawIdToken != refreshedIdToken || auth.AccessToken != "refreshed-access-token" || auth.RefreshToken != "rotated-refresh-token" {
+		t.Fatalf("Unexpected tokens: %v", auth)
+	}
+
+	// a refresh response without a scope keeps the stored scope
+	auth, err = providers.ValidateTokens(ctx, expiredIdToken, (&oauth2.Token{
+		RefreshToken: "refresh-token",
+	}).WithExtra(map[string]interface{}{
+		"scope": "openid orders:read",
+	}))
+	if err != nil || !slices.Equal(auth.Scopes, []string{"openid", "orders:read"}) {
+		t.Fatalf("Unexpected scopes: %v %v", auth, err)
+	}
+
+	// the refreshed identity must be the same
+	otherClaims := signer.idTokenClaims("")
+	otherClaims["sub"] = "another-subject"
+	otherServer := testTokenEndpoint(t, signer.sign(t, otherClaims))
+	defer otherServer.Close()
+	providers.state.Load().oauth2Config.Endpoint.TokenURL = otherServer.URL
+
+	_, err = providers.ValidateJwt(ctx, expiredIdToken, "access-token", "refresh-token")
+	if !errors.Is(err, ErrTokenIdentityMismatch) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateTokensRefreshWithoutIdToken(t *testing.T) {
+	ctx := context.Background()
+	signer := newTestSigner(t)
+
+	expiredClaims := signer.idTokenClaims("")
+	expiredClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+	expiredIdToken := signer.sign(t, expiredClaims)
+
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "refreshed-access-token",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": "rotated-refresh-token",
+		})
+	}))
+	defer server.Close()
+	providers := signer.providers()
+	providers.state.Load().oauth2Config.Endpoint.TokenURL = server.URL
+
+	auth, err := providers.ValidateJwt(ctx, expiredIdToken, "access-token", "refresh-token")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.RawIdToken != expiredIdToken || auth.AccessToken != "refreshed-access-token" || time.Until(auth.Expiry) < 50*time.Minute {
+		t.Fatalf("Unexpected auth: %v", auth)
+	}
+
+	// the original identity is good until the access token expires
+	auth, err = providers.ValidateTokens(ctx, expiredIdToken, &oauth2.Token{
+		AccessToken:  auth.AccessToken,
+		RefreshToken: auth.RefreshToken,
+		Expiry:       auth.Expiry,
+	})
+	if err != nil || auth.Subject != "test-subject" || auth.AccessToken != "refreshed-access-token" {
+		t.Fatalf("Unexpected auth: %v %v", auth, err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("Unexpected token requests: %v", requests.Load())
+	}
+}
+
+func TestRefreshFailures(t *testing.T) {
+	ctx := context.Background()
+	signer := newTestSigner(t)
+
+	expiredClaims := signer.idTokenClaims("")
+	expiredClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+	expiredIdToken := signer.sign(t, expiredClaims)
+
+	for name, test := range map[string]struct {
+		status   int
+		body     map[string]any
+		expected error
+	}{
+		"invalid grant": {http.StatusBadRequest, map[string]any{"error": "invalid_grant"}, ErrTokenExpired},
+		"unavailable":   {http.StatusServiceUnavailable, nil, ErrEndpoint},
+		"server error":  {http.StatusInternalServerError, map[string]any{"error": "server_error"}, ErrEndpoint},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.status)
+			_ = json.NewEncoder(w).Encode(test.body)
+		}))
+		providers := signer.providers()
+		providers.state.Load().oauth2Config.Endpoint.TokenURL = server.URL
+
+		_, err := providers.ValidateJwt(ctx, expiredIdToken, "access-token", "refresh-token")
+		server.Close()
+		if !errors.Is(err, test.expected) {
+			t.Fatalf("%v: Unexpected error: %v", name, err)
+		}
+		if test.expected != ErrTokenExpired && errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("%v: a transient failure is not an expired session: %v", name, err)
+		}
+	}
+}
+
+func TestInitializationBackoff(t *testing.T) {
+	ctx := context.Background()
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:           server.URL,
+			InitRetryBackoff: time.Hour,
+		},
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := providers.Initialize(ctx); !errors.Is(err, ErrInitialization) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requests.Load() != 1 {
+		t.Fatalf("Unexpected discovery requests: %v", requests.Load())
+	}
+}
+
+// serves a discovery document, with a token endpoint that can be changed
+type testDiscovery struct {
+	server        *httptest.Server
+	tokenEndpoint atomic.Value
+}
+
+func newTestDiscovery(t *testing.T) *testDiscovery {
+	obj := &testDiscovery{}
+	obj.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 obj.server.URL,
+			"authorization_endpoint": obj.server.URL + "/auth",
+			"token_endpoint":         obj.tokenEndpoint.Load(),
+			"jwks_uri":               obj.server.URL + "/jwks",
+		})
+	}))
+	obj.tokenEndpoint.Store(obj.server.URL + "/token")
+	t.Cleanup(obj.server.Close)
+	return obj
+}
+
+func TestDiscoveryRefresh(t *testing.T) {
+	ctx := context.Background()
+	discovery := newTestDiscovery(t)
+
+	changed := make(chan ProviderMetadata, 1)
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:                        discovery.server.URL,
+			ClientId:                      testClientId,
+			DiscoveryRefreshAfterFailures: 2,
+			OnMetadataChanged: func(previous ProviderMetadata, current ProviderMetadata) {
+				changed <- current
+			},
+		},
+	}
+	if err := providers.Initialize(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old token endpoint has gone away
+	discovery.tokenEndpoint.Store(discovery.server.URL + "/token/v2")
+	for i := 0; i < 2; i++ {
+		if _, err := providers.Exchange(ctx, "code"); err == nil {
+			t.Fatal("Expected exchange to fail")
+		}
+	}
+
+	select {
+	case metadata := <-changed:
+		if metadata.TokenEndpoint != discovery.server.URL+"/token/v2" {
+			t.Fatalf("Unexpected metadata: %v", metadata)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Discovery was not refreshed")
+	}
+	oauth2Config, err := providers.Oauth2Config(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oauth2Config.Endpoint.TokenURL != discovery.server.URL+"/token/v2" {
+		t.Fatalf("Unexpected token endpoint: %v", oauth2Config.Endpoint.TokenURL)
+	}
+}
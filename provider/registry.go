@@ -0,0 +1,274 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Registry is a set of named providers.
+// Tokens are routed to their provider by the 'iss' claim, anything that
+// isn't token specific goes to the default provider
+type Registry struct {
+	defaultName string
+	names       []string
+	providers   map[string]*OidcProviders
+}
+
+var _ Providers = (*Registry)(nil)
+
+// NewRegistry requires a unique issuer per provider, so that tokens can be routed
+func NewRegistry(defaultName string, defaultProvider *OidcProviders, others map[string]*OidcProviders) (*Registry, error) {
+	registry := &Registry{
+		defaultName: defaultName,
+		names:       []string{defaultName},
+		providers:   map[string]*OidcProviders{defaultName: defaultProvider},
+	}
+	otherNames := make([]string, 0, len(others))
+	for name := range others {
+		otherNames = append(otherNames, name)
+	}
+	slices.Sort(otherNames)
+
+	issuers := map[string]string{defaultProvider.issuer(): defaultName}
+	for _, name := range otherNames {
+		if _, exists := registry.providers[name]; exists || name == "" {
+			return nil, fmt.Errorf("invalid provider name: %q", name)
+		}
+		issuer := others[name].issuer()
+		if existing, exists := issuers[issuer]; exists {
+			return nil, fmt.Errorf("providers %q and %q have the same issuer: %v", existing, name, issuer)
+		}
+		issuers[issuer] = name
+		registry.names = append(registry.names, name)
+		registry.providers[name] = others[name]
+	}
+	return registry, nil
+}
+
+// the provider names, default first
+func (obj *Registry) Names() []string {
+	return slices.Clone(obj.names)
+}
+
+// the expected issuer of each provider, in Names order
+func (obj *Registry) Issuers() []string {
+	issuers := make([]string, 0, len(obj.names))
+	for _, name := range obj.names {
+		issuers = append(issuers, obj.providers[name].issuer())
+	}
+	return issuers
+}
+
+func (obj *Registry) DefaultName() string {
+	return obj.defaultName
+}
+
+func (obj *Registry) Default() *OidcProviders {
+	return obj.providers[obj.defaultName]
+}
+
+// Get a provider by name, blank is the default provider
+func (obj *Registry) Get(name string) (*OidcProviders, error) {
+	if name == "" {
+		return obj.Default(), nil
+	}
+	providers, ok := obj.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+	return providers, nil
+}
+
+// ForToken finds the provider for a (not yet verified) jwt, by its 'iss' claim
+func (obj *Registry) ForToken(rawJwt string) (*OidcProviders, error) {
+	issuer, err := unverifiedIssuer(rawJwt)
+	if err != nil {
+		return nil, EnsureErr(err, ErrNotAuthorized)
+	}
+	for _, name := range obj.names {
+		if obj.providers[name].issuer() == issuer {
+			return obj.providers[name], nil
+		}
+	}
+	return nil, EnsureErr(fmt.Errorf("%w: issuer %v", ErrUnknownProvider, issuer), ErrNotAuthorized)
+}
+
+// the 'iss' claim, WITHOUT verifying anything
+func unverifiedIssuer(rawJwt string) (string, error) {
+	claims := struct {
+		Issuer string `json:"iss"`
+	}{}
+	err := UnverifiedClaims(rawJwt, &claims)
+	return claims.Issuer, err
+}
+
+// UnverifiedClaims unmarshals the claims of a jwt WITHOUT verifying anything,
+// so only use it on tokens that have already been verified, or to route them
+func UnverifiedClaims(rawJwt string, claims any) error {
+	parts := strings.Split(rawJwt, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	return nil
+}
+
+// initializes every provider
+func (obj *Registry) Initialize(ctx context.Context) error {
+	errs := make([]error, 0)
+	for _, name := range obj.names {
+		if err := obj.providers[name].Initialize(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("provider %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (obj *Registry) ValidateJwt(ctx context.Context, rawIdToken string, accessToken string, refreshToken string) (*ProviderAuth, error) {
+	if rawIdToken == "" {
+		return nil, ErrNoAuth
+	}
+	providers, err := obj.ForToken(rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+	return providers.ValidateJwt(ctx, rawIdToken, accessToken, refreshToken)
+}
+
+func (obj *Registry) ValidateTokens(ctx context.Context, rawIdToken string, tokens *oauth2.Token) (*ProviderAuth, error) {
+	if rawIdToken == "" {
+		return nil, ErrNoAuth
+	}
+	providers, err := obj.ForToken(rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+	return providers.ValidateTokens(ctx, rawIdToken, tokens)
+}
+
+func (obj *Registry) ValidateTokenResponse(ctx context.Context, oauth2Token *oauth2.Token, nonce string) (*ProviderAuth, error) {
+	rawIdToken, _ := oauth2Token.Extra("id_token").(string)
+	if rawIdToken == "" {
+		return nil, ErrNoIdToken
+	}
+	providers, err := obj.ForToken(rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+	return providers.ValidateTokenResponse(ctx, oauth2Token, nonce)
+}
+
+func (obj *Registry) VerifyIdToken(ctx context.Context, rawIdToken string, nonce string) (*gooidc.IDToken, error) {
+	if rawIdToken == "" {
+		return nil, ErrNoIdToken
+	}
+	providers, err := obj.ForToken(rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+	return providers.VerifyIdToken(ctx, rawIdToken, nonce)
+}
+
+func (obj *Registry) VerifyLogoutToken(ctx context.Context, rawLogoutToken string) (*LogoutToken, error) {
+	if rawLogoutToken == "" {
+		return nil, ErrInvalidLogoutToken
+	}
+	providers, err := obj.ForToken(rawLogoutToken)
+	if err != nil {
+		return nil, EnsureErr(err, ErrInvalidLogoutToken)
+	}
+	return providers.VerifyLogoutToken(ctx, rawLogoutToken)
+}
+
+// exchanges with the default provider, use Get(name).Exchange for the others
+func (obj *Registry) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return obj.Default().Exchange(ctx, code, opts...)
+}
+
+func (obj *Registry) ValidateAccessToken(ctx context.Context, rawAccessToken string) (*ProviderAuth, error) {
+	if rawAccessToken == "" {
+		return nil, ErrNoAuth
+	}
+	providers, err := obj.ForToken(rawAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return providers.ValidateAccessToken(ctx, rawAccessToken)
+}
+
+// introspects with the provider that issued the token if it is a jwt,
+// otherwise with the default provider
+func (obj *Registry) IntrospectToken(ctx context.Context, accessToken string) (*ProviderAuth, error) {
+	providers, err := obj.ForToken(accessToken)
+	if err != nil {
+		providers = obj.Default()
+	}
+	return providers.IntrospectToken(ctx, accessToken)
+}
+
+// revokes with the provider that issued the token if it is a jwt.
+// Opaque tokens (eg: refresh tokens) can only be revoked here if there is
+// a single provider, otherwise use Get(name).RevokeToken
+func (obj *Registry) RevokeToken(ctx context.Context, token string, hint string) error {
+	providers, err := obj.ForToken(token)
+	if err != nil {
+		if len(obj.names) > 1 {
+			return EnsureErr(err, ErrUnroutableToken)
+		}
+		providers = obj.Default()
+	}
+	return providers.RevokeToken(ctx, token, hint)
+}
+
+// refreshes every provider
+func (obj *Registry) Refresh(ctx context.Context) error {
+	errs := make([]error, 0)
+	for _, name := range obj.names {
+		if err := obj.providers[name].Refresh(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("provider %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RefreshPeriodically runs each providers RefreshPeriodically,
+// until the context is done
+func (obj *Registry) RefreshPeriodically(ctx context.Context) {
+	for _, name := range obj.names {
+		go obj.providers[name].RefreshPeriodically(ctx)
+	}
+}
+
+func (obj *Registry) Metadata(ctx context.Context) (*ProviderMetadata, error) {
+	return obj.Default().Metadata(ctx)
+}
+
+func (obj *Registry) GoOidcProvider(ctx context.Context) (*gooidc.Provider, error) {
+	return obj.Default().GoOidcProvider(ctx)
+}
+
+func (obj *Registry) Oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	return obj.Default().Oauth2Config(ctx)
+}
+
+func (obj *Registry) IdTokenVerifier(ctx context.Context) (*gooidc.IDTokenVerifier, error) {
+	return obj.Default().IdTokenVerifier(ctx)
+}
+
+func (obj *Registry) AccessTokenVerifier(ctx context.Context) (*AccessTokenVerifier, error) {
+	return obj.Default().AccessTokenVerifier(ctx)
+}
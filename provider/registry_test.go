@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestRegistry(t *testing.T) {
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: other.key.Public(), Use: "sig"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const otherIssuer = "https://other.example.com"
+	otherProviders := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:         otherIssuer,
+			ClientId:       testClientId,
+			StaticMetadata: &StaticMetadata{Jwks: jwks},
+		},
+	}
+
+	registry, err := NewRegistry("default", signer.providers(), map[string]*OidcProviders{"other": otherProviders})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := registry.Names(); len(names) != 2 || names[0] != "default" || names[1] != "other" {
+		t.Fatalf("Unexpected names: %v", names)
+	}
+	if _, err = registry.Get("missing"); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// routed by issuer
+	auth, err := registry.ValidateJwt(context.Background(), signer.sign(t, signer.idTokenClaims("")), "", "")
+	if err != nil || auth.GetIdToken().Issuer != testIssuer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	otherClaims := other.idTokenClaims("")
+	otherClaims["iss"] = otherIssuer
+	auth, err = registry.ValidateJwt(context.Background(), other.sign(t, otherClaims), "", "")
+	if err != nil || auth.GetIdToken().Issuer != otherIssuer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the other providers issuer, but not its key
+	_, err = registry.ValidateJwt(context.Background(), signer.sign(t, otherClaims), "", "")
+	if !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	otherClaims["iss"] = "https://unknown.example.com"
+	_, err = registry.ValidateJwt(context.Background(), other.sign(t, otherClaims), "", "")
+	if !errors.Is(err, ErrNotAuthorized) || !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// an opaque token can't be sent to whichever provider is the default
+	err = registry.RevokeToken(context.Background(), "opaque-refresh-token", TokenTypeHintRefreshToken)
+	if !errors.Is(err, ErrUnroutableToken) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = NewRegistry("default", signer.providers(), map[string]*OidcProviders{"same": signer.providers()})
+	if err == nil {
+		t.Fatal("Expected an error for a duplicate issuer")
+	}
+}
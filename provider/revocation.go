@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// token type hints, for revocation (and introspection)
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// EndpointError is an error response from a provider endpoint
+// (eg: revocation), with the OAuth2 error code if there was one
+type EndpointError struct {
+	Endpoint    string
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *EndpointError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%v: %v: %v %v %v", ErrEndpoint, e.Endpoint, e.StatusCode, e.Code, e.Description)
+	}
+	return fmt.Sprintf("%v: %v: %v", ErrEndpoint, e.Endpoint, e.StatusCode)
+}
+
+func (e *EndpointError) Is(target error) bool {
+	return target == ErrEndpoint
+}
+
+// the http client from the context (see oauth2.HTTPClient), like the token endpoint uses
+func httpClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// postForm posts to a provider endpoint, authenticated with the client
+// credentials (client_secret_basic), and returns the body of a 200 response
+func (obj *OidcProviders) postForm(ctx context.Context, endpoint string, form url.Values) ([]byte, error) {
+	config := obj.OidcProviderConfig
+	if config.ClientSecret == "" {
+		// a public client
+		form.Set("client_id", config.ClientId)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientSecret != "" {
+		// see https://www.rfc-editor.org/rfc/rfc6749#section-2.3.1
+		req.SetBasicAuth(url.QueryEscape(config.ClientId), url.QueryEscape(config.ClientSecret))
+	}
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		endpointError := &EndpointError{
+			Endpoint:   endpoint,
+			StatusCode: resp.StatusCode,
+		}
+		errorResponse := struct {
+			Code        string `json:"error"`
+			Description string `json:"error_description"`
+		}{}
+		if json.Unmarshal(body, &errorResponse) == nil {
+			endpointError.Code = errorResponse.Code
+			endpointError.Description = errorResponse.Description
+		}
+		return nil, endpointError
+	}
+	return body, nil
+}
+
+// RevokeToken revokes a refresh token (or access token, if the provider
+// supports it), with an optional token type hint
+// see https://www.rfc-editor.org/rfc/rfc7009
+func (obj *OidcProviders) RevokeToken(ctx context.Context, token string, hint string) error {
+	metadata, err := obj.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	if metadata.RevocationEndpoint == "" {
+		return ErrNoRevocationEndpoint
+	}
+	form := url.Values{}
+	form.Set("token", token)
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+	// an invalid (or already revoked) token is still a 200
+	_, err = obj.postForm(ctx, metadata.RevocationEndpoint, form)
+	return err
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevokeToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok || clientId != testClientId || clientSecret != "secret" || r.ParseForm() != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PostForm.Get("token_type_hint") == TokenTypeHintAccessToken {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_token_type"})
+			return
+		}
+		if r.PostForm.Get("token") != "refresh-token" {
+			t.Errorf("Unexpected token: %v", r.PostForm.Get("token"))
+		}
+	}))
+	defer server.Close()
+
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:       testIssuer,
+			ClientId:     testClientId,
+			ClientSecret: "secret",
+			StaticMetadata: &StaticMetadata{
+				ProviderMetadata: ProviderMetadata{
+					JwksUri:            server.URL + "/jwks",
+					RevocationEndpoint: server.URL + "/revoke",
+				},
+			},
+		},
+	}
+
+	err := providers.RevokeToken(context.Background(), "refresh-token", TokenTypeHintRefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = providers.RevokeToken(context.Background(), "access-token", TokenTypeHintAccessToken)
+	endpointError := &EndpointError{}
+	if !errors.Is(err, ErrEndpoint) || !errors.As(err, &endpointError) || endpointError.Code != "unsupported_token_type" || endpointError.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	providers.OidcProviderConfig.ClientSecret = "wrong"
+	err = providers.RevokeToken(context.Background(), "refresh-token", TokenTypeHintRefreshToken)
+	if !errors.As(err, &endpointError) || endpointError.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	providers = &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:         testIssuer,
+			StaticMetadata: &StaticMetadata{ProviderMetadata: ProviderMetadata{JwksUri: server.URL + "/jwks"}},
+		},
+	}
+	if err = providers.RevokeToken(context.Background(), "refresh-token", ""); !errors.Is(err, ErrNoRevocationEndpoint) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
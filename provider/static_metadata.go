@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+)
+
+// StaticMetadata is used instead of discovery, eg: for air gapped
+// environments or integration tests.
+// Signing keys come from the Jwks document, the JwksFile, or the JwksUri
+// (in that order)
+type StaticMetadata struct {
+	// the Issuer defaults to the configured Issuer
+	ProviderMetadata
+
+	// OPTIONAL
+	// the JWKS document
+	Jwks []byte
+
+	// OPTIONAL
+	// a file containing the JWKS document
+	JwksFile string
+}
+
+func (obj *OidcProviders) newStaticState(ctx context.Context) (*providerState, error) {
+	static := obj.OidcProviderConfig.StaticMetadata
+	metadata := static.ProviderMetadata
+	if metadata.Issuer == "" {
+		metadata.Issuer = obj.OidcProviderConfig.Issuer
+	}
+
+	keySet, err := static.keySet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	goOidcProvider := (&gooidc.ProviderConfig{
+		IssuerURL:   metadata.Issuer,
+		AuthURL:     metadata.AuthorizationEndpoint,
+		TokenURL:    metadata.TokenEndpoint,
+		UserInfoURL: metadata.UserInfoEndpoint,
+		JWKSURL:     metadata.JwksUri,
+		Algorithms:  metadata.SigningAlgs,
+	}).NewProvider(ctx)
+	return obj.buildState(&metadata, goOidcProvider, keySet), nil
+}
+
+func (obj *StaticMetadata) keySet(ctx context.Context) (gooidc.KeySet, error) {
+	jwks := obj.Jwks
+	if len(jwks) == 0 && obj.JwksFile != "" {
+		var err error
+		jwks, err = os.ReadFile(obj.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(jwks) != 0 {
+		return parseJwks(jwks)
+	}
+	if obj.JwksUri != "" {
+		return remoteKeySet(ctx, obj.JwksUri), nil
+	}
+	return nil, errors.New("static metadata requires a jwks document, file, or uri")
+}
+
+// parseJwks returns the (public) signing keys from a JWKS document
+func parseJwks(jwks []byte) (*gooidc.StaticKeySet, error) {
+	keySet := jose.JSONWebKeySet{}
+	err := json.Unmarshal(jwks, &keySet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	publicKeys := make([]crypto.PublicKey, 0)
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey := key.Public()
+		if publicKey.Key == nil {
+			continue
+		}
+		publicKeys = append(publicKeys, publicKey.Key)
+	}
+	if len(publicKeys) == 0 {
+		return nil, errors.New("jwks contains no signing keys")
+	}
+	return &gooidc.StaticKeySet{PublicKeys: publicKeys}, nil
+}
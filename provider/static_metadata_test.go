@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestStaticMetadata(t *testing.T) {
+	signer := newTestSigner(t)
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: signer.key.Public(), Use: "sig", Algorithm: "RS256", KeyID: "k1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(jwksFile, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, static := range map[string]*StaticMetadata{
+		"document": {Jwks: jwks},
+		"file":     {JwksFile: jwksFile},
+	} {
+		static.TokenEndpoint = "https://issuer.example.com/token"
+		static.EndSessionEndpoint = "https://issuer.example.com/logout"
+		providers := &OidcProviders{
+			OidcProviderConfig: OidcProviderConfig{
+				Issuer:         testIssuer,
+				ClientId:       testClientId,
+				StaticMetadata: static,
+			},
+		}
+
+		auth, err := providers.ValidateJwt(context.Background(), signer.sign(t, signer.idTokenClaims("")), "", "")
+		if err != nil || !auth.Valid {
+			t.Fatalf("%v: Unexpected error: %v", name, err)
+		}
+		metadata, err := providers.Metadata(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.Issuer != testIssuer || metadata.EndSessionEndpoint != static.EndSessionEndpoint {
+			t.Fatalf("%v: Unexpected metadata: %+v", name, metadata)
+		}
+	}
+
+	other := newTestSigner(t)
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:         testIssuer,
+			ClientId:       testClientId,
+			StaticMetadata: &StaticMetadata{Jwks: jwks},
+		},
+	}
+	_, err = providers.ValidateJwt(context.Background(), other.sign(t, other.idTokenClaims("")), "", "")
+	if !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	providers = &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:         testIssuer,
+			StaticMetadata: &StaticMetadata{},
+		},
+	}
+	if err = providers.Initialize(context.Background()); !errors.Is(err, ErrInitialization) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestStaticMetadataJwksUri(t *testing.T) {
+	signer := newTestSigner(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: signer.key.Public(), Use: "sig", Algorithm: "RS256", KeyID: "k1"}},
+		})
+	}))
+	defer server.Close()
+
+	providers := &OidcProviders{
+		OidcProviderConfig: OidcProviderConfig{
+			Issuer:   testIssuer,
+			ClientId: testClientId,
+			StaticMetadata: &StaticMetadata{
+				ProviderMetadata: ProviderMetadata{JwksUri: server.URL},
+			},
+		},
+	}
+	// only the server's client trusts its certificate, so the keys can
+	// only be fetched with the client from the initialization context
+	ctx := gooidc.ClientContext(context.Background(), server.Client())
+	if err := providers.Initialize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := providers.ValidateJwt(context.Background(), signer.sign(t, signer.idTokenClaims("")), "", "")
+	if err != nil || !auth.Valid {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
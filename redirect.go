@@ -0,0 +1,91 @@
+package fiberoidc
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isSafeRelativePath only allows paths on this origin
+// (ie: not '//evil.com' or '/\evil.com', which browsers treat as hosts)
+func isSafeRelativePath(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return false
+	}
+	if strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// isAllowlisted checks an absolute url against the allowlist
+// entries may be a host, a wildcard host (*.example.com), or an absolute url prefix
+func isAllowlisted(target string, allowlist []string) bool {
+	if strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || u.User != nil {
+		return false
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, entry := range allowlist {
+		entry = strings.ToLower(entry)
+		switch {
+		case strings.Contains(entry, "://"):
+			// scheme and host must match exactly, so that a prefix
+			// can't be extended into another domain
+			prefix, err := url.Parse(entry)
+			if err == nil && prefix.Scheme == u.Scheme && prefix.Host == strings.ToLower(u.Host) && isPathUnder(u.Path, prefix.Path) {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return true
+			}
+		default:
+			if host == entry || strings.ToLower(u.Host) == entry {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPathUnder matches the prefix on a segment boundary ('/return' allows
+// '/return' and '/return/x', not '/returnevil'), and never dot segments
+func isPathUnder(path string, prefix string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	if prefix == "" || path == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// safeRedirectTarget returns the target if it is allowed, otherwise the fallback path
+func (cfg *WebAppConfig) safeRedirectTarget(target string) string {
+	if isSafeRelativePath(target) || isAllowlisted(target, cfg.PostLoginRedirectAllowlist) {
+		return target
+	}
+	return cfg.PostLoginFallbackPath
+}
+
+// default LoginSuccessHandler, assumes the state is the path to redirect to
+func (cfg *WebAppConfig) redirectToState(state string, c *fiber.Ctx) error {
+	return c.Redirect(cfg.safeRedirectTarget(state), 302)
+}
@@ -0,0 +1,45 @@
+package fiberoidc
+
+import "testing"
+
+func TestSafeRedirectTarget(t *testing.T) {
+	cfg := &WebAppConfig{
+		PostLoginFallbackPath: "/home",
+		PostLoginRedirectAllowlist: []string{
+			"app.example.com",
+			"*.trusted.example.com",
+			"https://other.example.com/return/",
+			"https://app2.example.com/return",
+		},
+	}
+
+	cases := map[string]string{
+		"":                                              "/home",
+		"/":                                             "/",
+		"/some/path?query=value":                        "/some/path?query=value",
+		"//evil.com":                                    "/home",
+		"/\\evil.com":                                   "/home",
+		"https://evil.com/":                             "/home",
+		"javascript:alert(1)":                           "/home",
+		"relative/path":                                 "/home",
+		"https://app.example.com/page":                  "https://app.example.com/page",
+		"https://user@app.example.com/page":             "/home",
+		"https://app.example.com.evil.com/":             "/home",
+		"https://a.trusted.example.com/":                "https://a.trusted.example.com/",
+		"https://trusted.example.com/":                  "/home",
+		"https://other.example.com/return/":             "https://other.example.com/return/",
+		"https://other.example.com/else/":               "/home",
+		"https://other.example.com.evil/":               "/home",
+		"https://other.example.com/return/../admin":     "/home",
+		"https://other.example.com/return/%2e%2e/admin": "/home",
+		"https://app2.example.com/return":               "https://app2.example.com/return",
+		"https://app2.example.com/return/page":          "https://app2.example.com/return/page",
+		"https://app2.example.com/returnevil":           "/home",
+		"https://app2.example.com/return/./page":        "/home",
+	}
+	for target, expected := range cases {
+		if actual := cfg.safeRedirectTarget(target); actual != expected {
+			t.Errorf("Unexpected redirect for %q: %v", target, actual)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package fiberoidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+// RevocationList records the sessions that have been logged out at the IdP
+// (see BackChannelLogoutHandler), and is checked on every request
+type RevocationList interface {
+	// revoke the session (sid), or every session of the subject (sub)
+	// that was authenticated before the logout
+	Revoke(logoutToken *provider.LogoutToken) error
+
+	// checks a verified id token
+	IsRevoked(idToken *gooidc.IDToken) (bool, error)
+}
+
+// StorageRevocationList is a RevocationList in a fiber.Storage
+type StorageRevocationList struct {
+	Storage fiber.Storage
+
+	// how long revocations are remembered for, which should be at least
+	// as long as the longest session
+	TTL time.Duration
+}
+
+var _ RevocationList = (*StorageRevocationList)(nil)
+
+// the claims used to match a revocation
+type revocationClaims struct {
+	SessionId string `json:"sid"`
+	AuthTime  int64  `json:"auth_time"`
+}
+
+// keys are hashed, as they are built from token claims
+func revocationStorageKey(kind string, issuer string, value string) string {
+	hash := sha256.Sum256([]byte(kind + "\x00" + issuer + "\x00" + value))
+	return "fiber-oidc-revoked:" + base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (obj *StorageRevocationList) Revoke(logoutToken *provider.LogoutToken) error {
+	revokedAt := []byte(strconv.FormatInt(logoutToken.IssuedAt.Unix(), 10))
+	if logoutToken.SessionId != "" {
+		err := obj.Storage.Set(revocationStorageKey("sid", logoutToken.Issuer, logoutToken.SessionId), revokedAt, obj.TTL)
+		if err != nil {
+			return err
+		}
+	}
+	if logoutToken.Subject != "" && logoutToken.SessionId == "" {
+		return obj.Storage.Set(revocationStorageKey("sub", logoutToken.Issuer, logoutToken.Subject), revokedAt, obj.TTL)
+	}
+	return nil
+}
+
+func (obj *StorageRevocationList) IsRevoked(idToken *gooidc.IDToken) (bool, error) {
+	claims := &revocationClaims{}
+	if err := idToken.Claims(claims); err != nil {
+		return false, err
+	}
+
+	// a revoked session id is never reused
+	if claims.SessionId != "" {
+		value, err := obj.Storage.Get(revocationStorageKey("sid", idToken.Issuer, claims.SessionId))
+		if err != nil || value != nil {
+			return value != nil, err
+		}
+	}
+
+	// the subject may have logged in again since, and refreshed tokens keep
+	// their auth_time (if the IdP sends it)
+	value, err := obj.Storage.Get(revocationStorageKey("sub", idToken.Issuer, idToken.Subject))
+	if err != nil || value == nil {
+		return false, err
+	}
+	revokedAt, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return false, err
+	}
+	authenticatedAt := idToken.IssuedAt.Unix()
+	if claims.AuthTime != 0 {
+		authenticatedAt = claims.AuthTime
+	}
+	return authenticatedAt <= revokedAt, nil
+}
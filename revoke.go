@@ -0,0 +1,64 @@
+package fiberoidc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+// the provider that issued the id token, otherwise the default provider
+func (obj *FiberOidcStruct) tokenProviders(idToken string) *provider.OidcProviders {
+	if idToken != "" {
+		if providers, err := obj.Registry.ForToken(idToken); err == nil {
+			return providers
+		}
+	}
+	return obj.Registry.Default()
+}
+
+// revokes the refresh token, and the access token where the provider
+// supports it. Nothing is revoked if there is no revocation endpoint
+func (obj *FiberOidcStruct) revokeTokens(ctx context.Context, providers *provider.OidcProviders, tokens *requestTokens) error {
+	errs := make([]error, 0)
+	if tokens.refreshToken != "" {
+		err := providers.RevokeToken(ctx, tokens.refreshToken, provider.TokenTypeHintRefreshToken)
+		if errors.Is(err, provider.ErrNoRevocationEndpoint) {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if tokens.accessToken != "" {
+		err := providers.RevokeToken(ctx, tokens.accessToken, provider.TokenTypeHintAccessToken)
+		endpointError := &provider.EndpointError{}
+		if errors.Is(err, provider.ErrNoRevocationEndpoint) || (errors.As(err, &endpointError) && endpointError.Code == "unsupported_token_type") {
+			err = nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// KillSession ends a server side session (eg: from an admin API), by
+// revoking its tokens at the IdP and deleting it.
+// The session is deleted even if revocation fails, in which case the
+// (structured, see provider.EndpointError) revocation errors are returned
+func (obj *FiberOidcStruct) KillSession(ctx context.Context, sessionId string) error {
+	if !obj.sessionMode() {
+		return errors.New("there is no session storage")
+	}
+	data, err := obj.loadSessionData(sessionId)
+	if err != nil || data == nil {
+		return err
+	}
+	err = obj.Config.SessionStorage.Delete(sessionStorageKey(sessionId))
+	if err != nil {
+		return err
+	}
+	return obj.revokeTokens(ctx, obj.tokenProviders(data.IdToken), &requestTokens{
+		idToken:      data.IdToken,
+		accessToken:  data.AccessToken,
+		refreshToken: data.RefreshToken,
+		sessionId:    sessionId,
+	})
+}
@@ -0,0 +1,75 @@
+package fiberoidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func TestKillSession(t *testing.T) {
+	revoked := make(map[string]string)
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ParseForm() != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		revoked[r.PostForm.Get("token_type_hint")] = r.PostForm.Get("token")
+		mutex.Unlock()
+		if r.PostForm.Get("token") == "bad-refresh-token" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	storage := NewMemoryStorage(time.Minute)
+	defer storage.Close()
+	config := &Config{OidcProviderConfig: staticProviderConfig("corporate")}
+	config.StaticMetadata.RevocationEndpoint = server.URL + "/revoke"
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.SessionStorage = storage
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saveSession := func(sessionId string, data *sessionData) {
+		value, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = storage.Set(sessionStorageKey(sessionId), value, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saveSession("session-1", &sessionData{AccessToken: "access-token", RefreshToken: "refresh-token"})
+	if err = fiberOidc.KillSession(context.Background(), "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	if revoked[provider.TokenTypeHintRefreshToken] != "refresh-token" || revoked[provider.TokenTypeHintAccessToken] != "access-token" {
+		t.Fatalf("Unexpected revocations: %v", revoked)
+	}
+	if value, _ := storage.Get(sessionStorageKey("session-1")); value != nil {
+		t.Fatal("Expected the session to be deleted")
+	}
+
+	// still deleted when revocation fails
+	saveSession("session-2", &sessionData{RefreshToken: "bad-refresh-token"})
+	err = fiberOidc.KillSession(context.Background(), "session-2")
+	endpointError := &provider.EndpointError{}
+	if !errors.As(err, &endpointError) || endpointError.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, _ := storage.Get(sessionStorageKey("session-2")); value != nil {
+		t.Fatal("Expected the session to be deleted")
+	}
+}
@@ -0,0 +1,121 @@
+package fiberoidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+type fiberOidcSessionLocalsKey struct{}
+
+// the token set, as kept in the SessionStorage
+type sessionData struct {
+	IdToken      string `json:"id_token"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// unix time, see ProviderAuth.Expiry
+	Expiry int64 `json:"expiry,omitempty"`
+
+	// see ProviderAuth.Scopes
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func (obj *FiberOidcStruct) sessionMode() bool {
+	return obj.Config.SessionStorage != nil
+}
+
+// the storage key is a hash of the session id, so the contents of the
+// storage can't be used as session cookies
+func sessionStorageKey(sessionId string) string {
+	hash := sha256.Sum256([]byte(sessionId))
+	return "fiber-oidc-session:" + base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// the session id from the session cookie, and its token set.
+// returns a nil session if there is none, or it has expired
+func (obj *FiberOidcStruct) loadSession(c *fiber.Ctx) (string, *sessionData, error) {
+	sessionId := obj.getCookie(c, obj.Config.SessionCookieName)
+	if sessionId == "" {
+		return "", nil, nil
+	}
+	data, err := obj.loadSessionData(sessionId)
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionId, data, nil
+}
+
+// the token set for a session id, nil if there is none
+func (obj *FiberOidcStruct) loadSessionData(sessionId string) (*sessionData, error) {
+	value, err := obj.Config.SessionStorage.Get(sessionStorageKey(sessionId))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	data := &sessionData{}
+	if err = json.Unmarshal(value, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// saveSession writes the token set into the store (under a new session
+// id if blank), and (re)sets the session cookie
+func (obj *FiberOidcStruct) saveSession(c *fiber.Ctx, sessionId string, userAuth *provider.ProviderAuth) (string, error) {
+	if sessionId == "" {
+		var err error
+		sessionId, err = randomString(32)
+		if err != nil {
+			return "", err
+		}
+	}
+	value, err := json.Marshal(&sessionData{
+		IdToken:      userAuth.RawIdToken,
+		AccessToken:  userAuth.AccessToken,
+		RefreshToken: userAuth.RefreshToken,
+		Expiry:       unixTime(userAuth.Expiry),
+		Scopes:       userAuth.Scopes,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = obj.Config.SessionStorage.Set(sessionStorageKey(sessionId), value, obj.Config.SessionTTL)
+	if err != nil {
+		return "", err
+	}
+	c.Locals(fiberOidcSessionLocalsKey{}, sessionId)
+	return sessionId, obj.setCookie(c, obj.Config.SessionCookieName, sessionId, time.Now().Add(obj.Config.SessionTTL))
+}
+
+func (obj *FiberOidcStruct) deleteSession(c *fiber.Ctx, sessionId string) error {
+	obj.clearCookie(c, obj.Config.SessionCookieName)
+	if sessionId == "" {
+		return nil
+	}
+	return obj.Config.SessionStorage.Delete(sessionStorageKey(sessionId))
+}
+
+// SessionId returns the server side session id bound to the request, if any
+func SessionId(c *fiber.Ctx) string {
+	sessionId, _ := c.Locals(fiberOidcSessionLocalsKey{}).(string)
+	return sessionId
+}
+
+// zero for no time
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func fromUnixTime(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
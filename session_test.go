@@ -0,0 +1,149 @@
+package fiberoidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kncept/fiber-oidc/provider"
+)
+
+func TestMemoryStorageExpiry(t *testing.T) {
+	storage := NewMemoryStorage(time.Millisecond)
+	defer storage.Close()
+
+	if err := storage.Set("key", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Set("expiring", []byte("value"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if value, _ := storage.Get("key"); string(value) != "value" {
+		t.Fatalf("Unexpected value: %v", value)
+	}
+	if value, _ := storage.Get("expiring"); value != nil {
+		t.Fatalf("Unexpected value: %v", value)
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	storage := NewMemoryStorage(time.Minute)
+	defer storage.Close()
+	obj := FiberOidcStruct{
+		Config: (&Config{
+			WebAppConfig: WebAppConfig{
+				SessionStorage: storage,
+			},
+		}).WithDefaults(),
+	}
+	userAuth := &provider.ProviderAuth{
+		RawIdToken:   "id-token",
+		AccessToken:  "access-token",
+		RefreshToken: "refresh-token",
+	}
+
+	sessionId := ""
+	cookies := execCookieHandler(t, nil, func(c *fiber.Ctx) error {
+		var err error
+		sessionId, err = obj.saveSession(c, "", userAuth)
+		return err
+	})
+	sessionCookie := cookies[obj.Config.SessionCookieName]
+	if sessionId == "" || sessionCookie == nil || sessionCookie.Value != sessionId {
+		t.Fatalf("Unexpected session cookie: %v", sessionCookie)
+	}
+	// nothing but the session id is sent to the browser
+	if len(cookies) != 1 {
+		t.Fatalf("Unexpected cookies: %v", cookies)
+	}
+
+	execCookieHandler(t, []*http.Cookie{sessionCookie}, func(c *fiber.Ctx) error {
+		tokens, err := obj.getRequestTokens(c, *obj.Config.AutoRefreshOnExpiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens.sessionId != sessionId || tokens.idToken != "id-token" || tokens.accessToken != "access-token" || tokens.refreshToken != "refresh-token" {
+			t.Fatalf("Unexpected tokens: %v", tokens)
+		}
+		return obj.deleteSession(c, sessionId)
+	})
+
+	execCookieHandler(t, []*http.Cookie{sessionCookie}, func(c *fiber.Ctx) error {
+		tokens, err := obj.getRequestTokens(c, *obj.Config.AutoRefreshOnExpiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens.idToken != "" {
+			t.Fatalf("Unexpected tokens: %v", tokens)
+		}
+		return nil
+	})
+}
+
+func TestSessionSurvivesRefreshFailure(t *testing.T) {
+	tokenStatus := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		w.WriteHeader(int(tokenStatus.Load()))
+		if tokenStatus.Load() == http.StatusBadRequest {
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		}
+	}))
+	defer server.Close()
+
+	idp := newTestIdP(t, "corporate")
+	idp.config.StaticMetadata.TokenEndpoint = server.URL
+	storage := NewMemoryStorage(time.Minute)
+	defer storage.Close()
+	config := &Config{OidcProviderConfig: idp.config}
+	config.RedirectUri = "http://localhost:3000/oauth2/callback"
+	config.SessionStorage = storage
+	fiberOidc, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := fiberOidc.(*FiberOidcStruct)
+
+	claims := idp.claims("alice")
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	sessionId := ""
+	execCookieHandler(t, nil, func(c *fiber.Ctx) error {
+		sessionId, err = obj.saveSession(c, "", &provider.ProviderAuth{
+			RawIdToken:   idp.sign(t, claims),
+			RefreshToken: "refresh-token",
+		})
+		return err
+	})
+
+	app := fiber.New()
+	app.Get("/public", obj.UnprotectedRoute(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	request := func() {
+		req := httptest.NewRequest(fiber.MethodGet, "/public", nil)
+		req.AddCookie(&http.Cookie{Name: obj.Config.SessionCookieName, Value: sessionId})
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the token endpoint is down, which doesn't end the session
+	tokenStatus.Store(http.StatusServiceUnavailable)
+	request()
+	if data, _ := obj.loadSessionData(sessionId); data == nil {
+		t.Fatal("Session was deleted")
+	}
+
+	// the refresh token is no longer valid, which does
+	tokenStatus.Store(http.StatusBadRequest)
+	request()
+	if data, _ := obj.loadSessionData(sessionId); data != nil {
+		t.Fatalf("Unexpected session: %v", data)
+	}
+}